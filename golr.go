@@ -1,25 +1,82 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"regexp"
+	"sort"
 	"time"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"os"
 	"os/exec"
 	"os/signal"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"slices"
+	"sync"
+	"syscall"
+	"github.com/fsnotify/fsnotify"
 	"github.com/jessevdk/go-flags"
+	"github.com/robfig/cron/v3"
+	"golang.org/x/sync/errgroup"
 )
 
 /* ----- */
 
 func FatalError(msg string) {
 	fmt.Println("*** Error:", msg)
+	RunExitHook()
 	os.Exit(1)
 }
 
+// The --on-exit command, run once however golr ends
+var exitHook string
+var exitHookOnce sync.Once
+
+func RunExitHook() {
+	if len(exitHook) == 0 {
+		return
+	}
+	exitHookOnce.Do(func() {
+		fmt.Printf("Running exit hook: %s\n", exitHook)
+		cmd := ShellCommand(context.Background(), exitHook)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("Exit hook failed: %s\n", err)
+		}
+	})
+}
+
+var tracing bool
+
+// Explains why golr did or didn't do something, with --trace
+func Trace(format string, args ...any) {
+	if tracing {
+		fmt.Printf("trace: "+format+"\n", args...)
+	}
+}
+
 /* ----- */
 
 type PStateErr struct {
+	Runner *Runner
 	PState *os.ProcessState
 	Err error
 }
@@ -29,234 +86,5651 @@ type PStateErr struct {
 type Scanner struct {
 	srcs []string
 	dirs []string
+	embeds []string
 	mtime time.Time
+	lastChanged string
+	gitFiles []string
+	gitMtime time.Time
+	gitSettle time.Duration
+	gitChange time.Time
+	gitPending bool
+	depFiles []string
+	changedFiles []string
+	changeType string
+	dirInfo map[string]os.FileInfo
+	graceStart time.Time
+	graceEnd time.Time
+	graceGlobs []string
+	showChanges bool
+	fileTypes map[string]string
+	failing map[string]bool
+	async bool
+	mutex sync.Mutex
+	scanErrors []error
+	sinceCommit string
+	emptyRecheck time.Duration
+	gone bool
+	goneCheck time.Time
+	extraGlobs []string
 }
 
 func NewScanner(srcs []string, dirs []string) *Scanner {
 	s := Scanner{}
 	s.srcs = srcs
 	s.dirs = dirs
+	s.embeds = FindEmbeds(srcs)
 	s.mtime = time.Now()
+	s.dirInfo = make(map[string]os.FileInfo)
+	s.fileTypes = make(map[string]string)
+	s.failing = make(map[string]bool)
+	s.trackDirs()
 	return &s
 }
 
-func (s *Scanner) detect() bool {
-
+// Remembers the directories of the watched files, to notice when one is replaced
+func (s *Scanner) trackDirs() {
 	for _, f := range s.srcs {
-		fi, err := os.Stat(f)
-		if err == nil {
-			mtime := fi.ModTime()
-			if mtime.After(s.mtime) {
-				s.mtime = mtime
-				fmt.Printf("Changed: %s\n", f)
-				return true
-			}
+		s.trackDir(filepath.Dir(f))
+	}
+	for _, f := range s.embeds {
+		s.trackDir(filepath.Dir(f))
+	}
+}
+
+func (s *Scanner) trackDir(dir string) {
+	if _, ok := s.dirInfo[dir]; !ok {
+		if fi, err := os.Stat(dir); err == nil {
+			s.dirInfo[dir] = fi
 		}
 	}
+}
 
+// Editors that save by renaming a temp directory over the real one can leave
+// files with old mtimes behind, the directory itself is new though
+func (s *Scanner) dirReplaced() bool {
+	for dir, fi := range s.dirInfo {
+		cur, err := s.stat(dir)
+		if err != nil {
+			// Possibly in the middle of the rename, look again next time
+			continue
+		}
+		if !os.SameFile(fi, cur) {
+			s.dirInfo[dir] = cur
+			s.mtime = time.Now()
+			s.lastChanged = dir
+			if !slices.Contains(s.changedFiles, dir) {
+				s.changedFiles = append(s.changedFiles, dir)
+			}
+			fmt.Printf("Changed: %s (directory replaced)\n", dir)
+			return true
+		}
+	}
 	return false
 }
 
-/* ----- */
-
-type Builder struct {
-	srcs []string
-	outfile string
+// Ignores changes made from the start of a build for a while, to all files
+// or just to the given build outputs
+func (s *Scanner) grace(start time.Time, length time.Duration, globs []string) {
+	// File times come from a coarse clock and can be a little behind ours
+	s.graceStart = start.Add(-100 * time.Millisecond)
+	s.graceEnd = start.Add(length)
+	s.graceGlobs = globs
 }
 
-func NewBuilder(outfile string, srcs []string) *Builder {
-	b := Builder{}
-	b.outfile = outfile
-	b.srcs = srcs
-	return &b
+func (s *Scanner) inGrace(f string, mtime time.Time) bool {
+	if mtime.Before(s.graceStart) || mtime.After(s.graceEnd) {
+		return false
+	}
+	if len(s.graceGlobs) == 0 {
+		return true
+	}
+	for _, glob := range s.graceGlobs {
+		if MatchGlob(glob, f) {
+			return true
+		}
+	}
+	return false
 }
 
-func (b *Builder) build() error {
-
-	fmt.Printf("Building: %s\n", b.srcs)
-
-	startTime := time.Now()
-
-	args := make([]string, 0, 10)
-	args = append(args, "build")
-	args = append(args, "-o")
-	args = append(args, b.outfile)
-	args = append(args, b.srcs...)
+func (s *Scanner) changed(f string) bool {
+	return s.changedSince(f, s.mtime)
+}
 
-	cmd := exec.Command("go", args...)
-	out, err := cmd.CombinedOutput()
+func (s *Scanner) changedSince(f string, since time.Time) bool {
+	fi, err := s.stat(f)
+	if err == nil {
+		mtime := fi.ModTime()
+		if mtime.After(since) && s.inGrace(f, mtime) {
+			// Written by the build itself, don't let it queue another one
+			Trace("%s: changed during --rebuild-grace, ignored", f)
+			if mtime.After(s.mtime) {
+				s.mtime = mtime
+			}
+			return false
+		}
+		if mtime.After(since) {
+			if mtime.After(s.mtime) {
+				s.mtime = mtime
+			}
+			s.lastChanged = f
+			if !slices.Contains(s.changedFiles, f) {
+				s.changedFiles = append(s.changedFiles, f)
+			}
+			fmt.Printf("Changed: %s\n", f)
+			return true
+		}
+	}
+	return false
+}
 
-	elapsedTime := time.Since(startTime)
+// A missing file is normal while an editor saves, other errors such as no
+// permission are reported once until the file can be read again
+func (s *Scanner) stat(f string) (os.FileInfo, error) {
+	fi, err := os.Stat(f)
+	if err != nil && !os.IsNotExist(err) {
+		if !s.failing[f] {
+			s.failing[f] = true
+			s.report(err)
+		}
+	} else if s.failing[f] {
+		delete(s.failing, f)
+	}
+	return fi, err
+}
 
-	if err != nil {
-		fmt.Printf("Build failed:\n%s\n", out)
+func (s *Scanner) report(err error) {
+	if s.async {
+		// Sent by run once the lock is released
+		s.scanErrors = append(s.scanErrors, err)
 	} else {
-		fmt.Printf("Build done: %s\n", elapsedTime)
+		Trace("Scan error: %s", err)
 	}
+}
 
-	return err
+// Scans every interval on its own instead of from the event loop, for
+// --scan-goroutine. The lock is held while scanning, not while sending.
+func (s *Scanner) run(interval time.Duration, changes chan *Scanner, errs chan error) {
+	for {
+		s.mutex.Lock()
+		changed := s.detect()
+		failed := s.scanErrors
+		s.scanErrors = nil
+		s.mutex.Unlock()
+
+		for _, err := range failed {
+			errs <- err
+		}
+		if changed {
+			changes <- s
+		}
+		time.Sleep(interval)
+	}
 }
 
-/* ----- */
+// Directories of everything the scanner looks at, for --hybrid notifications
+func (s *Scanner) notifyDirs() []string {
+	dirs := make([]string, 0, len(s.dirInfo))
+	for dir := range s.dirInfo {
+		dirs = append(dirs, dir)
+	}
+	for _, f := range s.watched() {
+		dirs = append(dirs, filepath.Dir(f))
+	}
+	for _, glob := range s.extraGlobs {
+		if strings.ContainsRune(glob, '/') || strings.ContainsRune(glob, filepath.Separator) {
+			dirs = append(dirs, filepath.Dir(glob))
+		}
+	}
+	return dirs
+}
 
-type Runner struct {
-	outfile string
-	args []string
-	pchan chan PStateErr
-	proc *os.Process
+func (s *Scanner) watched() []string {
+	files := make([]string, 0, len(s.srcs)+len(s.embeds)+len(s.gitFiles))
+	files = append(files, s.srcs...)
+	files = append(files, s.embeds...)
+	files = append(files, s.gitFiles...)
+	files = append(files, s.depFiles...)
+	files = append(files, s.extraFiles()...)
+	return files
 }
 
-func NewRunner(outfile string, args []string, pchan chan PStateErr) *Runner {
-	r := Runner{}
-	r.outfile = outfile
-	r.args = args
-	r.pchan = pchan
-	r.proc = nil
-	return &r
+// Watches only the Go files that differ from ref, committed or not, plus go.mod and go.sum
+func (s *Scanner) watchSinceCommit(ref string) error {
+	files, err := GitChangedFiles(ref)
+	if err != nil {
+		return err
+	}
+	// Before narrowing anything, a failure leaves all the sources watched
+	if err := s.watchDeps(); err != nil {
+		return err
+	}
+	s.srcs = files
+	s.sinceCommit = ref
+	s.embeds = FindEmbeds(s.srcs)
+	s.trackDirs()
+	fmt.Printf("Watching %d file(s) changed since %s\n", len(files), ref)
+	return nil
 }
 
-func (r *Runner) spawn() error {
-	argv := make([]string, 0, 10)
-	argv = append(argv, r.outfile)
-	argv = append(argv, r.args...)
+// Go files changed since ref and untracked ones, deleted files are left out.
+// Other untracked files include build outputs, which would rebuild in a loop.
+func GitChangedFiles(ref string) ([]string, error) {
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, fmt.Errorf("not in a git repository")
+	}
+	root := strings.TrimSpace(string(out))
 
-	attr := &os.ProcAttr{}
-	attr.Files = make([]*os.File, 0, 3)
-	attr.Files = append(attr.Files, os.Stdin)
-	attr.Files = append(attr.Files, os.Stdout)
-	attr.Files = append(attr.Files, os.Stderr)
+	diff, err := exec.Command("git", "-C", root, "diff", "--name-only", ref).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff %s failed: %s", ref, err)
+	}
+	untracked, err := exec.Command("git", "-C", root, "ls-files", "--others", "--exclude-standard").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files failed: %s", err)
+	}
 
-	fmt.Printf("Starting %s %s\n", r.outfile, argv[1:])
+	files := make([]string, 0)
+	for _, name := range strings.Split(string(diff)+string(untracked), "\n") {
+		if filepath.Ext(name) != ".go" {
+			continue
+		}
+		path := filepath.Join(root, name)
+		if _, err := os.Stat(path); err == nil && !slices.Contains(files, path) {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
 
-	proc, err := os.StartProcess(r.outfile, argv, attr)
+// Rebuilds when go get changes the module's requirements, no local source changes then
+func (s *Scanner) watchDeps() error {
+	root, err := FindModuleRoot()
 	if err != nil {
 		return err
 	}
+	s.depFiles = []string{filepath.Join(root, "go.mod"), filepath.Join(root, "go.sum")}
+	return nil
+}
 
-	go func() {
-		fmt.Printf("Waiting on %s\n", r.outfile)
-		pstate, err := proc.Wait()
-		r.pchan <- PStateErr{pstate, err}
-	}()
-
-	r.proc = proc
+func (s *Scanner) watchGit(settle time.Duration) error {
+	gitDir, err := FindGitDir()
+	if err != nil {
+		return err
+	}
+	s.gitFiles = []string{filepath.Join(gitDir, "HEAD"), filepath.Join(gitDir, "index")}
+	s.gitMtime = time.Now()
+	s.gitSettle = settle
 	return nil
 }
 
-func (r *Runner) kill() bool {
-	if r.proc != nil {
-		r.proc.Kill()
-		r.proc = nil
-		return true
+func (s *Scanner) gitChanged() bool {
+	changed := false
+	for _, f := range s.gitFiles {
+		fi, err := os.Stat(f)
+		if err == nil && fi.ModTime().After(s.gitMtime) {
+			s.gitMtime = fi.ModTime()
+			changed = true
+		}
 	}
-	return false
+	return changed
 }
 
-/* ----- */
+// Files matching the extra globs, a glob without a directory is looked up next
+// to the sources, e.g. the .c files of a cgo package
+func (s *Scanner) extraFiles() []string {
+	files := make([]string, 0)
+	for _, glob := range s.extraGlobs {
+		if strings.ContainsRune(glob, '/') || strings.ContainsRune(glob, filepath.Separator) {
+			files = append(files, ExpandGlobs([]string{glob})...)
+			continue
+		}
+		dirs := make([]string, 0)
+		for _, f := range s.srcs {
+			if dir := filepath.Dir(f); !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+		for _, dir := range dirs {
+			matches, _ := filepath.Glob(filepath.Join(dir, glob))
+			files = append(files, matches...)
+		}
+	}
+	return files
+}
 
-const (
-	building = iota
-	running = iota
-	killing = iota
-	exiting = iota
-)
+// Stops at the first file that's there, usually the first one
+func (s *Scanner) allMissing() bool {
+	for _, f := range s.srcs {
+		if _, err := os.Stat(f); err == nil || !os.IsNotExist(err) {
+			return false
+		}
+	}
+	return true
+}
 
-/* ----- */
+// Whether the sources are back after all going missing, which counts as a
+// change even if they kept their old times
+func (s *Scanner) back() bool {
+	if time.Since(s.goneCheck) < s.emptyRecheck {
+		return false
+	}
+	s.goneCheck = time.Now()
 
-type Flags struct {
-	OutFile string `short:"o" long:"outfile" description:"Executable file" default:"lr-bin"`
-	Dirs []string `short:"d" long:"dirs" description:"Directory to watch"`
+	if len(s.sinceCommit) != 0 {
+		// The set itself may have been emptied, e.g. by git stash
+		if files, err := GitChangedFiles(s.sinceCommit); err == nil && len(files) != 0 {
+			s.watchSinceCommit(s.sinceCommit)
+		}
+	}
+	if s.allMissing() {
+		return false
+	}
+
+	s.gone = false
+	s.mtime = time.Now()
+	s.embeds = FindEmbeds(s.srcs)
+	s.trackDirs()
+	s.lastChanged = "watched files"
+	s.changeType = "source"
+	fmt.Printf("Watched files are back, rebuilding\n")
+	return true
 }
 
-func main() {
-	var args_this = os.Args[1:]
-	var args_child = make([]string, 0)
+func (s *Scanner) detect() bool {
 
-	for i, val := range args_this {
-		if val == "--" {
-			args_child = args_this[i+1:]
-			args_this = args_this[:i]
-			break
+	if len(s.gitFiles) != 0 {
+		if s.gitChanged() {
+			if !s.gitPending {
+				fmt.Printf("Git operation detected, waiting for it to settle\n")
+			}
+			s.gitPending = true
+			s.gitChange = time.Now()
+		}
+		if s.gitPending {
+			if time.Since(s.gitChange) < s.gitSettle {
+				return false
+			}
+			// One rebuild for everything the git operation touched
+			s.gitPending = false
+			since := s.mtime
+			s.mtime = time.Now()
+			s.embeds = FindEmbeds(s.srcs)
+			for _, f := range slices.Concat(s.srcs, s.embeds) {
+				// Listed for the build, too many to print one by one
+				fi, err := os.Stat(f)
+				if err == nil && fi.ModTime().After(since) && !slices.Contains(s.changedFiles, f) {
+					s.changedFiles = append(s.changedFiles, f)
+				}
+			}
+			s.lastChanged = "git working tree"
+			s.changeType = "git"
+			fmt.Printf("Changed: git working tree\n")
+			return true
 		}
 	}
 
-	var opts Flags
-	srcs, err := flags.ParseArgs(&opts, args_this)
-	if err != nil {
-		os.Exit(1)
+	// Without this a tree that was briefly empty could come back unnoticed
+	if s.emptyRecheck > 0 {
+		if s.gone {
+			return s.back()
+		}
+		if s.allMissing() {
+			if len(s.srcs) == 0 {
+				fmt.Printf("No files to watch, looking again every %s\n", s.emptyRecheck)
+			} else {
+				fmt.Printf("All watched files are gone, looking for them every %s\n", s.emptyRecheck)
+			}
+			s.gone = true
+			s.goneCheck = time.Now()
+			return false
+		}
 	}
 
-	if len(srcs) == 0 {
-		FatalError("No source files")
+	// All of them, files saved in the same burst must not be lost once the
+	// time moves past them, hooks and the build's environment want the list
+	since := s.mtime
+	found := false
+	for _, f := range s.depFiles {
+		if s.changedSince(f, since) {
+			s.fileTypes[f] = "dependencies"
+			s.changeType = "dependencies"
+			found = true
+		}
 	}
-
-	if len(opts.OutFile) == 0 {
-		FatalError("No output file")
+	if found {
+		fmt.Printf("Dependencies changed, rebuilding\n")
 	}
 
-	outfile, err := filepath.Abs(opts.OutFile)
-	if err != nil {
-		FatalError(err.Error())
+	if s.dirReplaced() {
+		s.embeds = FindEmbeds(s.srcs)
+		s.trackDirs()
+		s.changeType = "source"
+		found = true
 	}
 
-	// Channels and signals
-	pchan := make(chan PStateErr)
-	cchan := make(chan os.Signal, 1)
-	signal.Notify(cchan, os.Interrupt, os.Kill)
+	for _, f := range s.srcs {
+		if s.changedSince(f, since) {
+			s.fileTypes[f] = "source"
+			found = true
+		}
+	}
+	if found {
+		// Embed directives may have been added or removed
+		s.embeds = FindEmbeds(s.srcs)
+		s.trackDirs()
+		s.changeType = "source"
+	}
 
-	// Change scanner
-	scanner := NewScanner(srcs, opts.Dirs)
+	// Embedded files are compiled into the binary, so they need a rebuild too
+	for _, f := range s.embeds {
+		if s.changedSince(f, since) {
+			s.fileTypes[f] = "embed"
+			if s.changeType != "source" {
+				s.changeType = "embed"
+			}
+			found = true
+		}
+	}
 
-	// Executable builder
-	builder := NewBuilder(outfile, srcs)
+	// Files of --build-override and when= hooks, such as cgo sources
+	for _, f := range s.extraFiles() {
+		if s.changedSince(f, since) {
+			s.fileTypes[f] = "source"
+			s.changeType = "source"
+			found = true
+		}
+	}
 
-	// Executable runner
-	runner := NewRunner(outfile, args_child, pchan)
+	return found
+}
 
-	// Event loop
-	state := building
-	for (state != exiting) {
-		if state == building {
-			// Building
-			err = builder.build()
-			if err != nil {
-				fmt.Println("Build failed", err)
-			} else {
-				runner.spawn()
+// Returns what changed since the last call, for the build's environment
+func (s *Scanner) takeChanges() ([]string, []string) {
+	changeType := s.changeType
+	if len(changeType) == 0 {
+		changeType = "initial"
+	}
+	env := []string{
+		"GOLR_CHANGED_FILES=" + strings.Join(s.changedFiles, string(os.PathListSeparator)),
+		"GOLR_CHANGE_TYPE=" + changeType,
+	}
+	files := s.changedFiles
+	if s.showChanges && len(files) != 0 {
+		fmt.Printf("Changes in this cycle:\n")
+		for _, f := range files {
+			fileType := s.fileTypes[f]
+			if len(fileType) == 0 {
+				fileType = changeType
 			}
-			state = running
-		} else if state == running || state == killing {
-			// Running or killing
+			fmt.Printf("  %-12s %s\n", fileType, f)
+		}
+	}
+	s.changedFiles = nil
+	s.changeType = ""
+	clear(s.fileTypes)
+	return env, files
+}
+
+/* ----- */
+
+// Wakes up the scan loop as soon as something changes in one of the directories
+func NotifyChanges(dirs []string) (chan string, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Directories that aren't there yet or were replaced are watched once they are
+	missing := make(map[string]bool)
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); os.IsNotExist(err) {
+			missing[dir] = true
+		} else if err != nil {
+			fmt.Printf("Can't watch %s: %s\n", dir, err)
+		}
+	}
+
+	// One pending wakeup is enough, the scan finds out what changed
+	nchan := make(chan string, 1)
+	wake := func(name string) {
+		select {
+		case nchan <- name:
+		default:
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
 			select {
-			default:
-				if scanner.detect() {
-					if runner.kill() {
-						state = killing
-					} else {
-						state = building
+			case <-ticker.C:
+				for dir := range missing {
+					// The old watch went with the old directory
+					watcher.Remove(dir)
+					if err := watcher.Add(dir); err == nil {
+						Trace("Watching %s again", dir)
+						delete(missing, dir)
+						wake(dir)
 					}
 				}
-
-			case pstate := <-pchan:
-				if pstate.Err != nil {
-					fmt.Printf("Process exited: %s\n", pstate.Err)
-				} else {
-					fmt.Printf("Process exited without error\n")
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
 				}
-				if (state == killing) {
-					state = building
-				} else {
-					state = exiting
+				if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+					if slices.Contains(dirs, event.Name) {
+						missing[event.Name] = true
+					}
 				}
+				wake(event.Name)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Printf("File notification error: %s\n", err)
+			}
+		}
+	}()
+	return nchan, nil
+}
 
-			case sig := <- cchan:
-				fmt.Printf("Signal: %s\n", sig)
-				state = exiting
+/* ----- */
+
+func FindGitDir() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		path := filepath.Join(dir, ".git")
+		fi, err := os.Stat(path)
+		if err == nil {
+			if fi.IsDir() {
+				return path, nil
+			}
+			// Worktrees and submodules have a file pointing to the real git dir
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", err
 			}
+			gitDir := strings.TrimSpace(strings.TrimPrefix(string(data), "gitdir:"))
+			if !filepath.IsAbs(gitDir) {
+				gitDir = filepath.Join(dir, gitDir)
+			}
+			return gitDir, nil
+		}
 
-			time.Sleep(250 * time.Millisecond)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("not in a git repository")
 		}
+		dir = parent
 	}
+}
 
-	fmt.Printf("Done running\n")
+/* ----- */
+
+// A named pipeline that runs a command when files matching its globs change
+type Watcher struct {
+	name string
+	globs []string
+	command string
+	scanner *Scanner
+	changeAt time.Time
+}
+
+// Parses [os:]name:glob[,glob...]:command, nil without an error when the os
+// prefix names another platform
+func ParseWatcher(spec string) (*Watcher, error) {
+	goos, rest, _ := CutOSPrefix(spec)
+	if len(goos) != 0 && goos != runtime.GOOS {
+		return nil, nil
+	}
+	parts := strings.SplitN(rest, ":", 3)
+	if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return nil, fmt.Errorf("bad watcher %q, expected name:glob[,glob]:command", spec)
+	}
+
+	return NewWatcher(parts[0], strings.Split(parts[1], ","), parts[2]), nil
+}
+
+// Parses [os:]dir:command, a watcher for the .sql files in dir. The child is only
+// restarted once the command succeeds, never against a half migrated database.
+// Nil without an error when the os prefix names another platform.
+func ParseMigrate(spec string) (*Watcher, error) {
+	goos, rest, _ := CutOSPrefix(spec)
+	if len(goos) != 0 && goos != runtime.GOOS {
+		return nil, nil
+	}
+	dir, command, ok := strings.Cut(rest, ":")
+	if !ok || len(dir) == 0 || len(command) == 0 {
+		return nil, fmt.Errorf("bad migration %q, expected dir:command", spec)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("bad migration %q, %s is not a directory", spec, dir)
+	}
+	return NewWatcher("migrate " + dir, []string{filepath.Join(dir, "*.sql")}, command), nil
+}
+
+func NewWatcher(name string, globs []string, command string) *Watcher {
+	w := Watcher{}
+	w.name = name
+	w.globs = globs
+	w.command = command
+	w.scanner = NewScanner(ExpandGlobs(w.globs), nil)
+	return &w
+}
+
+func (w *Watcher) detect() bool {
+	// Globs are expanded on every scan to pick up new files
+	w.scanner.srcs = ExpandGlobs(w.globs)
+	return w.scanner.detect()
+}
+
+func (w *Watcher) run() error {
+	fmt.Printf("Running %s: %s\n", w.name, w.command)
+
+	startTime := time.Now()
+
+	// Tells the command which files changed, as for build hooks
+	env, _ := w.scanner.takeChanges()
+	cmd := ShellCommand(rootContext, w.command)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+
+	if err != nil {
+		fmt.Printf("%s failed:\n%s\n", w.name, out)
+	} else {
+		fmt.Printf("%s done: %s\n", w.name, time.Since(startTime))
+	}
+
+	return err
+}
+
+/* ----- */
+
+// A command run before or after each build
+type Hook struct {
+	command string
+	failOnStderr bool
+	when string
+	goos string
+}
+
+// Parses [os:][fail_on_stderr:][when=glob:]command, the prefixes in any order
+func ParseHook(spec string) *Hook {
+	h := Hook{}
+	h.command = spec
+	for {
+		if goos, rest, ok := CutOSPrefix(h.command); ok {
+			h.command = rest
+			h.goos = goos
+		} else if rest, ok := strings.CutPrefix(h.command, "fail_on_stderr:"); ok {
+			h.command = rest
+			h.failOnStderr = true
+		} else if rest, ok := strings.CutPrefix(h.command, "when="); ok {
+			if glob, command, ok := strings.Cut(rest, ":"); ok {
+				h.when = glob
+				h.command = command
+				continue
+			}
+			break
+		} else {
+			break
+		}
+	}
+	return &h
+}
+
+func (h *Hook) forThisOS() bool {
+	return len(h.goos) == 0 || h.goos == runtime.GOOS
+}
+
+// A hook with a when glob only runs if a changed file matches it, by name or
+// by path. Without a list of changes (the first build) it always runs.
+func (h *Hook) wanted(changed []string) bool {
+	if !h.forThisOS() {
+		return false
+	}
+	if len(h.when) == 0 || len(changed) == 0 {
+		return true
+	}
+	for _, f := range changed {
+		if MatchGlob(h.when, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// Build flags and environment for the cycles where a changed file matches
+type BuildOverride struct {
+	globs []string
+	flags []string
+	env []string
+}
+
+// Parses glob[,glob]:setting..., each setting a build flag like -tags=x or KEY=VALUE
+func ParseBuildOverride(spec string) (*BuildOverride, error) {
+	globs, settings, ok := strings.Cut(spec, ":")
+	if !ok || len(globs) == 0 || len(strings.TrimSpace(settings)) == 0 {
+		return nil, fmt.Errorf("bad build override %q, expected glob[,glob]:flags, e.g. *.c,*.h:CGO_ENABLED=1", spec)
+	}
+
+	o := BuildOverride{}
+	o.globs = strings.Split(globs, ",")
+	for _, setting := range strings.Fields(settings) {
+		if strings.HasPrefix(setting, "-") {
+			o.flags = append(o.flags, setting)
+		} else if strings.Contains(setting, "=") {
+			o.env = append(o.env, setting)
+		} else {
+			return nil, fmt.Errorf("bad build override %q, %s is neither a -flag nor KEY=VALUE", spec, setting)
+		}
+	}
+	return &o, nil
+}
+
+func (o *BuildOverride) matches(changed []string) bool {
+	for _, f := range changed {
+		for _, glob := range o.globs {
+			if MatchGlob(glob, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Matches by base name or by the whole path
+func MatchGlob(glob string, path string) bool {
+	if ok, _ := filepath.Match(glob, filepath.Base(path)); ok {
+		return true
+	}
+	ok, _ := filepath.Match(glob, path)
+	return ok
+}
+
+func (h *Hook) run(ctx context.Context, env []string) error {
+	fmt.Printf("Running hook: %s\n", h.command)
+
+	var stderr bytes.Buffer
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd := ShellCommand(ctx, h.command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = pw
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(io.MultiWriter(os.Stderr, &stderr), pr)
+		close(copied)
+	}()
+	err = cmd.Wait()
+	select {
+	case <-copied:
+	case <-ctx.Done():
+		// Once canceled, don't wait for something the hook started that still has its stderr
+		select {
+		case <-copied:
+		case <-time.After(time.Second):
+		}
+	}
+	pr.Close()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil && h.failOnStderr && stderr.Len() != 0 {
+		return fmt.Errorf("hook wrote to stderr")
+	}
+	return err
+}
+
+func RunHooks(ctx context.Context, hooks []*Hook, env []string, changed []string) error {
+	for _, h := range hooks {
+		if !h.wanted(changed) {
+			Trace("hook %s: not for this OS or no changed file matches %s", h.command, h.when)
+			continue
+		}
+		if err := h.run(ctx, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* ----- */
+
+func ExpandGlobs(globs []string) []string {
+	files := make([]string, 0)
+	for _, glob := range globs {
+		matches, err := filepath.Glob(glob)
+		if err == nil {
+			files = append(files, matches...)
+		}
+	}
+	return files
+}
+
+/* ----- */
+
+// Fires when a file's content starts matching a regex, not on every write
+type ContentWatch struct {
+	path string
+	re *regexp.Regexp
+	mtime time.Time
+	matched bool
+	changeAt time.Time
+}
+
+// Parses path:regex
+func ParseContentWatch(spec string) (*ContentWatch, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return nil, fmt.Errorf("bad content watch %q, expected path:regex", spec)
+	}
+
+	re, err := regexp.Compile(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	c := ContentWatch{}
+	c.path = parts[0]
+	c.re = re
+	c.matched = c.match()
+	return &c, nil
+}
+
+func (c *ContentWatch) match() bool {
+	data, err := os.ReadFile(c.path)
+	return err == nil && c.re.Match(data)
+}
+
+func (c *ContentWatch) detect() bool {
+	fi, err := os.Stat(c.path)
+	if err == nil && fi.ModTime().Equal(c.mtime) {
+		return false
+	}
+	if err == nil {
+		c.mtime = fi.ModTime()
+	}
+
+	matched := c.match()
+	fired := matched && !c.matched
+	c.matched = matched
+
+	if fired {
+		fmt.Printf("Content matched: %s\n", c.path)
+	}
+	return fired
+}
+
+/* ----- */
+
+// Returns the files matched by //go:embed directives in the given Go sources
+func FindEmbeds(srcs []string) []string {
+	embeds := make([]string, 0)
+
+	for _, src := range srcs {
+		if filepath.Ext(src) != ".go" {
+			continue
+		}
+
+		data, err := os.ReadFile(src)
+		if err != nil {
+			continue
+		}
+
+		dir := filepath.Dir(src)
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "//go:embed ") {
+				continue
+			}
+			for _, pattern := range parseEmbedPatterns(line[len("//go:embed "):]) {
+				pattern = strings.TrimPrefix(pattern, "all:")
+				matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+				if err != nil {
+					continue
+				}
+				for _, m := range matches {
+					filepath.WalkDir(m, func(path string, d os.DirEntry, err error) error {
+						if err == nil && !d.IsDir() {
+							embeds = append(embeds, path)
+						}
+						return nil
+					})
+				}
+			}
+		}
+	}
+
+	return embeds
+}
+
+func parseEmbedPatterns(s string) []string {
+	patterns := make([]string, 0)
+
+	for {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			break
+		}
+
+		if s[0] == '"' || s[0] == '`' {
+			end := strings.IndexByte(s[1:], s[0])
+			if end < 0 {
+				break
+			}
+			quoted := s[:end+2]
+			s = s[end+2:]
+			if p, err := strconv.Unquote(quoted); err == nil {
+				patterns = append(patterns, p)
+			}
+		} else {
+			end := strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			patterns = append(patterns, s[:end])
+			s = s[end:]
+		}
+	}
+
+	return patterns
+}
+
+/* ----- */
+
+// What go build needs to work at all with --clean-env
+var cleanEnv = []string{"PATH", "HOME", "GOPATH", "GOCACHE"}
+
+// Windows programs also need these to start or find temp and profile dirs
+var cleanEnvWindows = []string{"SYSTEMROOT", "TEMP", "TMP", "USERPROFILE", "LOCALAPPDATA", "APPDATA"}
+
+// For programs that buffer their output when it's not a terminal but can be told not to
+var lineBufferedEnv = []string{"PYTHONUNBUFFERED=1"}
+
+var errNoSpace = errors.New("no space left on device")
+var errNotReady = errors.New("child not ready in time")
+
+type Builder struct {
+	srcs []string
+	outfile string
+	flags []string
+	json bool
+	touch bool
+	failOn *regexp.Regexp
+	explain bool
+	test bool
+	cover bool
+	elapsed time.Duration
+	compiled int
+	output []byte
+	env []string
+	cycleFlags []string
+	keepEnv []string
+	cacheDir string
+	remote *Remote
+}
+
+func NewBuilder(outfile string, srcs []string, flags []string) *Builder {
+	b := Builder{}
+	b.outfile = outfile
+	b.srcs = srcs
+	b.flags = flags
+	return &b
+}
+
+func (b *Builder) args(extra ...string) []string {
+	args := make([]string, 0, 10)
+	if b.test {
+		// Compiles the package's tests into a binary instead of running them
+		args = append(args, "test", "-c")
+	} else {
+		args = append(args, "build")
+	}
+	args = append(args, extra...)
+	args = append(args, b.flags...)
+	args = append(args, b.cycleFlags...)
+	args = append(args, "-o")
+	args = append(args, b.outfile)
+	args = append(args, b.srcs...)
+	return args
+}
+
+func (b *Builder) validate() error {
+	// A dry run catches bad flags without compiling anything
+	cmd := exec.Command("go", b.args("-n")...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Printf("Build flags rejected: %s\n%s\n", b.flags, out)
+	}
+	return err
+}
+
+func (b *Builder) build(ctx context.Context) error {
+
+	fmt.Printf("Building: %s\n", b.srcs)
+
+	startTime := time.Now()
+	b.compiled = -1
+
+	extra := make([]string, 0)
+	if b.json {
+		extra = append(extra, "-json")
+	}
+	if b.cover {
+		extra = append(extra, "-cover")
+	}
+	if b.explain {
+		// -v lists the packages that were actually compiled, not the cached ones
+		extra = append(extra, "-v")
+	}
+
+	var cmd *exec.Cmd
+	if b.remote != nil {
+		if err := b.remote.sync(ctx, filepath.Base(b.outfile)); err != nil {
+			return err
+		}
+		// The binary stays on the remote side, next to the sources
+		variant := *b
+		variant.outfile = "./" + filepath.Base(b.outfile)
+		srcs, err := b.remote.paths(b.srcs)
+		if err != nil {
+			return err
+		}
+		variant.srcs = srcs
+		cmd = b.remote.command(ctx, "go", variant.args(extra...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, "go", b.args(extra...)...)
+	}
+	cmd.Env = append(b.environ(), b.env...)
+	out, err := cmd.CombinedOutput()
+
+	if b.json && err != nil && strings.Contains(string(out), "flag provided but not defined: -json") {
+		fmt.Printf("This Go version doesn't support go build -json, using plain output\n")
+		b.json = false
+		return b.build(ctx)
+	}
+
+	elapsedTime := time.Since(startTime)
+	b.elapsed = elapsedTime
+	b.output = out
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err == nil && b.failOn != nil {
+		if m := b.failOn.Find(out); m != nil {
+			fmt.Printf("Build output matched --fail-on-output: %s\n", m)
+			return fmt.Errorf("output matched %s", b.failOn)
+		}
+	}
+
+	if err != nil && bytes.Contains(out, []byte("no space left on device")) {
+		// Not a code problem, and building again won't help until space is freed
+		fmt.Printf("Build failed, the disk is full:\n%s\n", out)
+		fmt.Printf("Free some space (go clean -cache helps), golr will build again on the next change\n")
+		return errNoSpace
+	}
+
+	if err != nil {
+		if b.json {
+			fmt.Printf("Build failed:\n%s\n", RenderBuildJSON(out, IsTerminal(os.Stdout)))
+		} else {
+			fmt.Printf("Build failed:\n%s\n", out)
+		}
+	} else {
+		fmt.Printf("Build done: %s\n", elapsedTime)
+		if b.explain {
+			b.explainBuild(out)
+		}
+		if b.touch && b.remote == nil {
+			// go build leaves the file alone when nothing changed
+			now := time.Now()
+			err = os.Chtimes(b.outfile, now, now)
+		}
+	}
+
+	return err
+}
+
+// Variables go gets, all of ours unless --clean-env limits them
+func (b *Builder) environ() []string {
+	env := os.Environ()
+	if b.keepEnv != nil {
+		env = FilterEnv(env, b.keepEnv)
+	}
+	if len(b.cacheDir) != 0 {
+		env = append(env, "GOCACHE="+b.cacheDir)
+	}
+	return env
+}
+
+// Files and bytes under dir, for --trace
+func DirUsage(dir string) (int, int64) {
+	files := 0
+	var size int64
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if fi, err := d.Info(); err == nil {
+				files += 1
+				size += fi.Size()
+			}
+		}
+		return nil
+	})
+	return files, size
+}
+
+// Keeps only the named variables, KEY=VALUE entries are added as given
+func FilterEnv(env []string, keep []string) []string {
+	names := make(map[string]bool)
+	out := make([]string, 0, len(keep))
+	for _, k := range keep {
+		if strings.Contains(k, "=") {
+			out = append(out, k)
+		} else {
+			names[strings.ToUpper(k)] = true
+		}
+	}
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if names[strings.ToUpper(key)] {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// Parses the sources without compiling, syntax errors show up much faster
+func (b *Builder) fastCheck(ctx context.Context) error {
+	paths := make([]string, 0, len(b.srcs))
+	for _, src := range b.srcs {
+		path := strings.TrimSuffix(src, "/...")
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "gofmt", append([]string{"-e", "-l"}, paths...)...)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		fmt.Printf("Syntax check failed:\n%s\n", stderr.Bytes())
+	}
+	return err
+}
+
+// Compiles for other GOOS/GOARCH pairs in parallel, discarding the binaries
+func (b *Builder) crossBuild(ctx context.Context, targets []string) error {
+	startTime := time.Now()
+
+	variant := *b
+	variant.outfile = os.DevNull
+	args := variant.args()
+
+	outs := make([][]byte, len(targets))
+	errs := make([]error, len(targets))
+
+	var g errgroup.Group
+	for i, target := range targets {
+		goos, goarch, _ := strings.Cut(target, "/")
+		g.Go(func() error {
+			cmd := exec.CommandContext(ctx, "go", args...)
+			cmd.Env = append(b.environ(), "GOOS="+goos, "GOARCH="+goarch)
+			outs[i], errs[i] = cmd.CombinedOutput()
+			return errs[i]
+		})
+	}
+	err := g.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	for i, target := range targets {
+		if errs[i] != nil {
+			fmt.Printf("Build for %s failed: %s\n%s\n", target, errs[i], outs[i])
+		} else {
+			fmt.Printf("Build for %s ok\n", target)
+		}
+	}
+	if err == nil {
+		fmt.Printf("Extra builds done: %s\n", time.Since(startTime))
+	}
+	return err
+}
+
+func (b *Builder) explainBuild(out []byte) {
+	args := make([]string, 0, 10)
+	args = append(args, "list", "-deps", "-f", "{{.ImportPath}}")
+	args = append(args, b.flags...)
+	args = append(args, b.srcs...)
+
+	cmd := exec.Command("go", args...)
+	list, err := cmd.Output()
+	if err != nil {
+		fmt.Printf("Can't list packages: %s\n", err)
+		return
+	}
+
+	total := len(strings.Fields(string(list)))
+	compiled := len(CompiledPackages(out))
+	b.compiled = compiled
+
+	fmt.Printf("Recompiled %d packages, %d cached\n", compiled, total-compiled)
+}
+
+// Package directories of the sources, in a form go and linters accept
+func (b *Builder) packageDirs() []string {
+	dirs := make([]string, 0, len(b.srcs))
+	seen := make(map[string]bool)
+	for _, src := range b.srcs {
+		dir := src
+		if filepath.Ext(src) == ".go" {
+			dir = filepath.Dir(src)
+		}
+		if !filepath.IsAbs(dir) && !strings.HasPrefix(dir, ".") {
+			dir = "./" + dir
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Runs the linter on the source packages with its output going to the terminal
+func (b *Builder) lint(ctx context.Context, command string) error {
+	command = command + " " + strings.Join(b.packageDirs(), " ")
+	fmt.Printf("Linting: %s\n", command)
+
+	startTime := time.Now()
+
+	cmd := ShellCommand(ctx, command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil {
+		fmt.Printf("Lint done: %s\n", time.Since(startTime))
+	}
+	return err
+}
+
+// Runs the source packages' tests, race checking them doesn't slow down the binary that runs
+func (b *Builder) runTests(ctx context.Context, race bool) error {
+	args := []string{"test"}
+	if race {
+		args = append(args, "-race")
+	}
+	args = append(args, b.packageDirs()...)
+	fmt.Printf("Testing: go %s\n", strings.Join(args, " "))
+
+	startTime := time.Now()
+
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Env = append(b.environ(), b.env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil {
+		fmt.Printf("Tests done: %s\n", time.Since(startTime))
+	}
+	return err
+}
+
+// Compiles every package in the module, including ones the binary doesn't import
+func (b *Builder) checkAll(ctx context.Context, env []string) error {
+	args := []string{"build", "-o", os.DevNull}
+	args = append(args, b.flags...)
+	args = append(args, "./...")
+	fmt.Printf("Checking module: go %s\n", strings.Join(args, " "))
+
+	startTime := time.Now()
+
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Env = append(b.environ(), env...)
+	if root, err := FindModuleRoot(); err == nil {
+		cmd.Dir = root
+	}
+	out, err := cmd.CombinedOutput()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("%s\n%s", err, bytes.TrimSpace(out))
+	}
+	fmt.Printf("Module check done: %s\n", time.Since(startTime))
+	return nil
+}
+
+// Copies the binary into dir, the child keeps running from the output file
+func (b *Builder) install(dir string) error {
+	data, err := os.ReadFile(b.outfile)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.Base(b.outfile))
+	if err := WriteFileAtomic(path, data, 0755); err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s\n", path)
+	return nil
+}
+
+type BuildInfo struct {
+	Commit string `json:"commit"`
+	Dirty bool `json:"dirty"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	BuildFlags []string `json:"build_flags"`
+}
+
+func (b *Builder) writeBuildInfo(path string) error {
+	info := BuildInfo{}
+	info.Commit, info.Dirty = GitCommit()
+	info.BuildTime = time.Now().Format(time.RFC3339)
+	info.GoVersion = GoVersion()
+	info.BuildFlags = b.flags
+	if info.BuildFlags == nil {
+		info.BuildFlags = []string{}
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, append(data, '\n'), 0644)
+}
+
+/* ----- */
+
+// Returns the commit checked out in the current directory and whether there are local changes
+func GitCommit() (string, bool) {
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", false
+	}
+	commit := strings.TrimSpace(string(out))
+
+	out, err = exec.Command("git", "status", "--porcelain").Output()
+	return commit, err == nil && len(out) != 0
+}
+
+func GoVersion() string {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Readers never see a partially written file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+/* ----- */
+
+// Returns the package names go build -v printed, also from -json output
+func CompiledPackages(out []byte) []string {
+	pkgs := make([]string, 0)
+
+	for _, line := range strings.Split(string(out), "\n") {
+		var ev BuildEvent
+		if err := json.Unmarshal([]byte(line), &ev); err == nil {
+			line = strings.TrimSpace(ev.Output)
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.ContainsAny(line, " :\t") {
+			continue
+		}
+		pkgs = append(pkgs, line)
+	}
+
+	return pkgs
+}
+
+/* ----- */
+
+var presets = map[string][]string{
+	"dev": {"-race"},
+	"release": {"-trimpath", "-ldflags=-s -w"},
+	"reproducible": {"-trimpath", "-buildvcs=false", "-ldflags=-buildid="},
+}
+
+// Returns the build flags of the named presets, with their -ldflags combined
+func PresetFlags(names []string) ([]string, error) {
+	flags := make([]string, 0)
+	ldflags := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		list, ok := presets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset %s", name)
+		}
+		for _, flag := range list {
+			if strings.HasPrefix(flag, "-ldflags=") {
+				ldflags = append(ldflags, strings.TrimPrefix(flag, "-ldflags="))
+			} else if !seen[flag] {
+				seen[flag] = true
+				flags = append(flags, flag)
+			}
+		}
+	}
+
+	if len(ldflags) != 0 {
+		flags = append(flags, "-ldflags="+strings.Join(ldflags, " "))
+	}
+	return flags, nil
+}
+
+/* ----- */
+
+type BuildEvent struct {
+	ImportPath string
+	Action string
+	Output string
+}
+
+type Diagnostic struct {
+	file string
+	line int
+	col int
+	msg string
+}
+
+var diagnosticRe = regexp.MustCompile(`^(.+?):(\d+)(?::(\d+))?: (.*)$`)
+
+// Groups the diagnostics from go build -json by file, sorted by position
+func RenderBuildJSON(out []byte, color bool) string {
+	diags := make(map[string][]Diagnostic)
+	other := make([]string, 0)
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var ev BuildEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			// Errors from the go command itself are not JSON
+			other = append(other, line)
+			continue
+		}
+		if ev.Action != "build-output" {
+			continue
+		}
+
+		for _, text := range strings.Split(strings.TrimRight(ev.Output, "\n"), "\n") {
+			if strings.HasPrefix(text, "# ") {
+				continue
+			}
+			m := diagnosticRe.FindStringSubmatch(text)
+			if m == nil {
+				other = append(other, text)
+				continue
+			}
+			d := Diagnostic{}
+			d.file = m[1]
+			d.line, _ = strconv.Atoi(m[2])
+			d.col, _ = strconv.Atoi(m[3])
+			d.msg = m[4]
+			diags[d.file] = append(diags[d.file], d)
+		}
+	}
+
+	files := make([]string, 0, len(diags))
+	for file := range diags {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	var sb strings.Builder
+	for _, file := range files {
+		list := diags[file]
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].line != list[j].line {
+				return list[i].line < list[j].line
+			}
+			return list[i].col < list[j].col
+		})
+
+		if color {
+			fmt.Fprintf(&sb, "\x1b[1m%s\x1b[0m\n", file)
+		} else {
+			fmt.Fprintf(&sb, "%s\n", file)
+		}
+		for _, d := range list {
+			pos := fmt.Sprintf("%d:%d", d.line, d.col)
+			if color {
+				fmt.Fprintf(&sb, "  \x1b[36m%-8s\x1b[0m \x1b[31m%s\x1b[0m\n", pos, d.msg)
+			} else {
+				fmt.Fprintf(&sb, "  %-8s %s\n", pos, d.msg)
+			}
+		}
+	}
+	for _, text := range other {
+		fmt.Fprintf(&sb, "%s\n", text)
+	}
+
+	return sb.String()
+}
+
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+/* ----- */
+
+// Child output passed through golr on its way to the terminal and log file
+type OutputPipe struct {
+	mutex sync.Mutex
+	log io.Writer
+	merge bool
+	grep *regexp.Regexp
+	maxRate int
+	window time.Time
+	lines int
+	suppressed int
+}
+
+func NewOutputPipe(log io.Writer) *OutputPipe {
+	o := OutputPipe{}
+	o.log = log
+	return &o
+}
+
+// Goes in front of each line of a child's output, the color only on the terminal
+type Prefix struct {
+	text string
+	color string
+}
+
+var prefixColors = []string{"\x1b[36m", "\x1b[33m", "\x1b[35m", "\x1b[32m", "\x1b[34m", "\x1b[31m"}
+
+// Pads names to the same width and gives each a color that stays the same between runs
+func MakePrefixes(names []string, color bool) []Prefix {
+	width := 0
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+
+	prefixes := make([]Prefix, 0, len(names))
+	for _, name := range names {
+		pr := Prefix{}
+		pr.text = fmt.Sprintf("%-*s | ", width, name)
+		if color {
+			h := fnv.New32a()
+			h.Write([]byte(name))
+			pr.color = prefixColors[h.Sum32()%uint32(len(prefixColors))]
+		}
+		prefixes = append(prefixes, pr)
+	}
+	return prefixes
+}
+
+func (o *OutputPipe) copy(src *os.File, term io.Writer, prefix Prefix, watch func(line []byte)) {
+	defer src.Close()
+
+	reader := bufio.NewReader(src)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) != 0 {
+			o.write(term, prefix, line)
+			if watch != nil {
+				watch(line)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+}
+
+func (o *OutputPipe) write(term io.Writer, prefix Prefix, line []byte) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	// The filter only applies to the terminal, the log file gets everything
+	if (o.grep == nil || o.grep.Match(ansiRe.ReplaceAll(line, nil))) && o.allow() {
+		if len(prefix.color) != 0 {
+			io.WriteString(term, prefix.color+prefix.text+"\x1b[0m")
+		} else {
+			io.WriteString(term, prefix.text)
+		}
+		term.Write(line)
+	}
+	if o.log != nil {
+		io.WriteString(o.log, prefix.text)
+		o.log.Write(line)
+	}
+}
+
+// Counts a line against --max-log-rate, false when it's over the limit
+func (o *OutputPipe) allow() bool {
+	if o.maxRate <= 0 {
+		return true
+	}
+	if time.Since(o.window) >= time.Second {
+		o.flush()
+	}
+	o.lines += 1
+	if o.lines <= o.maxRate {
+		return true
+	}
+	if o.suppressed == 0 {
+		// The storm may end right here, report it without waiting for the next line
+		time.AfterFunc(time.Until(o.window.Add(time.Second)), func() {
+			o.mutex.Lock()
+			defer o.mutex.Unlock()
+			if time.Since(o.window) >= time.Second {
+				o.flush()
+			}
+		})
+	}
+	o.suppressed += 1
+	return false
+}
+
+func (o *OutputPipe) flush() {
+	if o.suppressed != 0 {
+		fmt.Printf("... %d lines suppressed ...\n", o.suppressed)
+	}
+	o.window = time.Now()
+	o.lines = 0
+	o.suppressed = 0
+}
+
+/* ----- */
+
+var ansiRe = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// Removes ANSI escape sequences, which must not be split across writes
+type AnsiStripper struct {
+	w io.Writer
+}
+
+func NewAnsiStripper(w io.Writer) *AnsiStripper {
+	a := AnsiStripper{}
+	a.w = w
+	return &a
+}
+
+func (a *AnsiStripper) Write(p []byte) (int, error) {
+	_, err := a.w.Write(ansiRe.ReplaceAll(p, nil))
+	return len(p), err
+}
+
+/* ----- */
+
+type Runner struct {
+	outfile string
+	args []string
+	stdinFile string
+	procName string
+	argsFile string
+	argsCmd string
+	pidfile string
+	remote *Remote
+	env []EnvLayer
+	envHints []string
+	dir string
+	sys *syscall.SysProcAttr
+	output *OutputPipe
+	prefix Prefix
+	netns bool
+	cgroup *MemoryCgroup
+	execTemplate string
+	reason string
+	readyLog *regexp.Regexp
+	pipeTo *PipeTo
+	hchan chan HealthResult
+	pchan chan PStateErr
+	proc *os.Process
+}
+
+func NewRunner(outfile string, args []string, stdinFile string, pchan chan PStateErr) *Runner {
+	r := Runner{}
+	r.outfile = outfile
+	r.args = args
+	r.stdinFile = stdinFile
+	r.pchan = pchan
+	r.proc = nil
+	r.reason = "initial"
+	return &r
+}
+
+func (r *Runner) spawn() error {
+	argv := make([]string, 0, 10)
+	if len(r.procName) != 0 {
+		argv = append(argv, r.procName)
+	} else {
+		argv = append(argv, r.outfile)
+	}
+	if len(r.argsFile) != 0 {
+		// Re-read on every spawn so edits take effect on reload
+		args, err := ReadArgsFile(r.argsFile)
+		if err != nil {
+			return err
+		}
+		argv = append(argv, args...)
+	}
+	argv = append(argv, r.args...)
+	if len(r.argsCmd) != 0 {
+		out, err := ShellCommand(rootContext, r.argsCmd).Output()
+		if err != nil {
+			return fmt.Errorf("--run-args-cmd failed: %s", err)
+		}
+		argv = append(argv, strings.Fields(string(out))...)
+	}
+
+	if r.remote == nil {
+		if err := VerifyBinary(r.outfile); err != nil {
+			return err
+		}
+	}
+
+	stdin := os.Stdin
+	if len(r.stdinFile) != 0 {
+		// Re-opened on every spawn so edits to the file take effect on reload
+		f, err := os.Open(r.stdinFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		stdin = f
+	}
+
+	attr := &os.ProcAttr{}
+	env, err := r.environ()
+	if err != nil {
+		return err
+	}
+	// Lets the child know why it was started: initial, rebuild, restart, cron or crash
+	attr.Env = append(env, "GOLR_RESTART_REASON="+r.reason)
+	attr.Dir = r.dir
+	attr.Sys = r.sys
+	if r.cgroup != nil {
+		sys, done, err := r.cgroup.sysProcAttr(r.sys)
+		if err != nil {
+			return err
+		}
+		defer done()
+		attr.Sys = sys
+	}
+	attr.Files = make([]*os.File, 0, 3)
+	attr.Files = append(attr.Files, stdin)
+
+	var pipeW *os.File
+	if r.pipeTo != nil {
+		w, err := r.pipeTo.writer()
+		if err != nil {
+			return fmt.Errorf("--pipe-to failed: %s", err)
+		}
+		pipeW = w
+	}
+
+	var outR, errR *os.File
+	if r.output != nil {
+		var outW, errW *os.File
+		var err error
+		if pipeW != nil {
+			// The downstream command gets stdout as is, only stderr is ours
+			outW = pipeW
+		} else if outR, outW, err = os.Pipe(); err != nil {
+			return err
+		}
+		if r.output.merge && pipeW == nil {
+			// One pipe for both keeps the child's writes in order
+			errW = outW
+		} else if errR, errW, err = os.Pipe(); err != nil {
+			if outR != nil {
+				outR.Close()
+				outW.Close()
+			}
+			return err
+		}
+		// Our copies of the write ends must be closed for the readers to see EOF
+		if outW != pipeW {
+			defer outW.Close()
+		}
+		if errW != outW {
+			defer errW.Close()
+		}
+		attr.Files = append(attr.Files, outW)
+		attr.Files = append(attr.Files, errW)
+	} else if pipeW != nil {
+		attr.Files = append(attr.Files, pipeW)
+		attr.Files = append(attr.Files, os.Stderr)
+	} else {
+		attr.Files = append(attr.Files, os.Stdout)
+		attr.Files = append(attr.Files, os.Stderr)
+	}
+
+	fmt.Printf("Starting %s %s\n", r.outfile, argv[1:])
+
+	exe := r.outfile
+	if r.remote != nil {
+		argv = r.remote.argv("./"+filepath.Base(r.outfile), argv[1:]...)
+		path, err := exec.LookPath("ssh")
+		if err != nil {
+			return err
+		}
+		exe = path
+	}
+
+	if len(r.execTemplate) != 0 {
+		command := r.expandTemplate(argv[1:])
+		fmt.Printf("Running: %s\n", command)
+		argv = ShellArgv(command)
+		path, err := exec.LookPath(argv[0])
+		if err != nil {
+			return err
+		}
+		exe = path
+	}
+
+	proc, err := os.StartProcess(exe, argv, attr)
+	if err != nil {
+		if outR != nil {
+			outR.Close()
+		}
+		if errR != nil {
+			errR.Close()
+		}
+		return err
+	}
+
+	if r.netns {
+		// Racy, but there's no hook between clone and exec to do it in
+		if err := LoopbackUp(proc.Pid); err != nil {
+			fmt.Printf("Can't bring up loopback in the child's namespace: %s\n", err)
+		}
+	}
+
+	var watch func(line []byte)
+	if r.readyLog != nil {
+		// The first matching line on either stream means the child is ready
+		var once sync.Once
+		watch = func(line []byte) {
+			if r.readyLog.Match(ansiRe.ReplaceAll(line, nil)) {
+				once.Do(func() {
+					// The loop may be busy, output must keep flowing meanwhile
+					go func() { r.hchan <- HealthResult{proc, nil} }()
+				})
+			}
+		}
+	}
+	if outR != nil {
+		go r.output.copy(outR, os.Stdout, r.prefix, watch)
+	}
+	if errR != nil {
+		go r.output.copy(errR, os.Stderr, r.prefix, watch)
+	}
+
+	go func() {
+		fmt.Printf("Waiting on %s\n", r.outfile)
+		pstate, err := proc.Wait()
+		r.pchan <- PStateErr{r, pstate, err}
+	}()
+
+	r.proc = proc
+	if len(r.pidfile) != 0 {
+		// Other tools may read it at any moment, never let them see a partial pid
+		if err := WriteFileAtomic(r.pidfile, []byte(strconv.Itoa(proc.Pid)+"\n"), 0644); err != nil {
+			fmt.Printf("Can't write %s: %s\n", r.pidfile, err)
+		}
+	}
+	return nil
+}
+
+// An interrupted build can leave an empty or half written file behind,
+// which would otherwise fail to start with a confusing error
+func VerifyBinary(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	if fi.Size() == 0 {
+		return fmt.Errorf("%s is empty, was the build interrupted?", path)
+	}
+	if !IsExecutable(fi) {
+		return fmt.Errorf("%s is not executable", path)
+	}
+	return nil
+}
+
+// The child's environment, env files are re-read each time so edits take effect on reload
+func (r *Runner) environ() ([]string, error) {
+	// Hints go first, anything set by the user wins
+	env := append(slices.Clone(r.envHints), os.Environ()...)
+	for _, layer := range r.env {
+		if len(layer.file) != 0 {
+			vars, err := ReadEnvFile(layer.file)
+			if err != nil {
+				return nil, err
+			}
+			env = append(env, vars...)
+		}
+		env = append(env, layer.vars...)
+	}
+	return DedupEnv(env), nil
+}
+
+// Parses a byte count like 512M or 2G, the suffixes are powers of 1024
+func ParseSize(s string) (int64, error) {
+	units := map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	value := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	unit := ""
+	if len(value) != 0 && strings.ContainsAny(value[len(value)-1:], "KMGT") {
+		value, unit = value[:len(value)-1], value[len(value)-1:]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad size %q, expected e.g. 512M", s)
+	}
+	return n * units[unit], nil
+}
+
+// Removes the pidfile if it's still about the process that exited
+func (r *Runner) removePidfile(pid int) {
+	if len(r.pidfile) == 0 {
+		return
+	}
+	data, err := os.ReadFile(r.pidfile)
+	if err == nil && strings.TrimSpace(string(data)) == strconv.Itoa(pid) {
+		os.Remove(r.pidfile)
+	}
+}
+
+// One argument per line, blank lines and lines starting with # are skipped
+func ReadArgsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args = append(args, line)
+	}
+
+	return args, nil
+}
+
+// Variables from a file and then a list, later layers override earlier ones
+type EnvLayer struct {
+	file string
+	vars []string
+}
+
+// KEY=VALUE per line, blank lines and lines starting with # are skipped
+func ReadEnvFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: bad line %q", path, line)
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars = append(vars, strings.TrimSpace(key)+"="+value)
+	}
+
+	return vars, nil
+}
+
+// os.StartProcess passes duplicates through, keep only the last value of each
+func DedupEnv(env []string) []string {
+	index := make(map[string]int)
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if i, ok := index[key]; ok {
+			out[i] = kv
+		} else {
+			index[key] = len(out)
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// Fills in {out} and {args} in --exec-template, quoted for the shell
+func (r *Runner) expandTemplate(args []string) string {
+	quote := ShellQuote
+	if runtime.GOOS == "windows" {
+		quote = func(s string) string { return `"` + s + `"` }
+	}
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, quote(arg))
+	}
+	command := strings.ReplaceAll(r.execTemplate, "{out}", quote(r.outfile))
+	return strings.ReplaceAll(command, "{args}", strings.Join(quoted, " "))
+}
+
+func (r *Runner) kill() bool {
+	if r.proc != nil && len(r.execTemplate) != 0 {
+		// The shell and everything in the pipeline
+		KillGroup(r.proc)
+		r.proc = nil
+		return true
+	}
+	if r.proc != nil {
+		r.proc.Kill()
+		r.proc = nil
+		return true
+	}
+	return false
+}
+
+/* ----- */
+
+// A long lived command reading the children's stdout, it outlives reloads
+// and is only started again if it exits on its own
+type PipeTo struct {
+	command string
+	w *os.File
+	done chan struct{}
+}
+
+func NewPipeTo(command string) *PipeTo {
+	t := PipeTo{}
+	t.command = command
+	return &t
+}
+
+// The write end for the next child, starting the command if it's not running
+func (t *PipeTo) writer() (*os.File, error) {
+	if t.w != nil {
+		select {
+		case <-t.done:
+			// Children still holding the old pipe get EPIPE
+			t.w.Close()
+			t.w = nil
+		default:
+			return t.w, nil
+		}
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd := ShellCommand(context.Background(), t.command)
+	cmd.Stdin = r
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	fmt.Printf("Piping output to: %s\n", t.command)
+
+	done := make(chan struct{})
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			fmt.Printf("Pipe command exited: %s\n", err)
+		} else {
+			fmt.Printf("Pipe command exited without error\n")
+		}
+		close(done)
+	}()
+
+	t.w = w
+	t.done = done
+	return w, nil
+}
+
+// Lets the command see EOF once the children are gone and waits for it a little
+func (t *PipeTo) close() {
+	if t.w == nil {
+		return
+	}
+	t.w.Close()
+	t.w = nil
+	select {
+	case <-t.done:
+	case <-time.After(5 * time.Second):
+		fmt.Printf("Pipe command still running, leaving it\n")
+	}
+}
+
+/* ----- */
+
+type Stats struct {
+	reloads int
+	builds int
+	failures int
+	crashes int
+	buildTime time.Duration
+	longestBuild time.Duration
+}
+
+func (st *Stats) addBuild(elapsed time.Duration, err error) {
+	st.builds += 1
+	st.buildTime += elapsed
+	if elapsed > st.longestBuild {
+		st.longestBuild = elapsed
+	}
+	if err != nil {
+		st.failures += 1
+	}
+}
+
+func (st *Stats) print() {
+	var average time.Duration
+	if st.builds > 0 {
+		average = st.buildTime / time.Duration(st.builds)
+	}
+
+	fmt.Printf("Session summary:\n")
+	fmt.Printf("  Reloads:        %d\n", st.reloads)
+	fmt.Printf("  Builds:         %d (%d failed)\n", st.builds, st.failures)
+	fmt.Printf("  Total build:    %s\n", st.buildTime)
+	fmt.Printf("  Average build:  %s\n", average)
+	fmt.Printf("  Longest build:  %s\n", st.longestBuild)
+	fmt.Printf("  Child crashes:  %d\n", st.crashes)
+}
+
+/* ----- */
+
+const daemonEnv = "GOLR_DAEMON"
+
+func StartDaemon(logfile string, pidfile string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	log, err := os.OpenFile(logfile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer null.Close()
+
+	attr := &os.ProcAttr{}
+	attr.Env = append(os.Environ(), daemonEnv+"=1")
+	attr.Files = []*os.File{null, log, log}
+	attr.Sys = DaemonSysProcAttr()
+
+	// The environment marker keeps the re-executed golr from forking again
+	proc, err := os.StartProcess(exe, os.Args, attr)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(pidfile, []byte(strconv.Itoa(proc.Pid)+"\n"), 0644)
+	if err != nil {
+		proc.Kill()
+		return err
+	}
+
+	fmt.Printf("Started daemon: pid %d, log %s\n", proc.Pid, logfile)
+	return proc.Release()
+}
+
+func StopDaemon(pidfile string) error {
+	data, err := os.ReadFile(pidfile)
+	if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("bad pid in %s: %s", pidfile, err)
+	}
+
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	err = StopProcess(proc)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Stopped daemon: pid %d\n", pid)
+	return nil
+}
+
+/* ----- */
+
+type MainPackage struct {
+	ImportPath string
+	GoFiles []string
+}
+
+// Lists the main packages matched by patterns like ./...
+func FindMainPackages(patterns []string) ([]MainPackage, error) {
+	args := []string{"list", "-f", `{{if eq .Name "main"}}{{.ImportPath}}{{range .GoFiles}}	{{$.Dir}}/{{.}}{{end}}{{end}}`}
+	cmd := exec.Command("go", append(args, patterns...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("go list failed:\n%s", exitErr.Stderr)
+		}
+		return nil, err
+	}
+
+	pkgs := make([]MainPackage, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		pkgs = append(pkgs, MainPackage{fields[0], fields[1:]})
+	}
+	return pkgs, nil
+}
+
+// Asks which package to run when there's more than one and a terminal to ask on
+func ChooseMainPackage(pkgs []MainPackage) (MainPackage, error) {
+	if len(pkgs) == 0 {
+		return MainPackage{}, fmt.Errorf("no main packages found")
+	}
+	if len(pkgs) == 1 {
+		return pkgs[0], nil
+	}
+
+	names := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		names = append(names, pkg.ImportPath)
+	}
+	if !IsTerminal(os.Stdin) || !IsTerminal(os.Stdout) {
+		return MainPackage{}, fmt.Errorf("several main packages found, specify one:\n  %s", strings.Join(names, "\n  "))
+	}
+
+	fmt.Printf("Several main packages found:\n")
+	for i, name := range names {
+		fmt.Printf("  %d) %s\n", i+1, name)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("Which one to run? ")
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return MainPackage{}, err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(line))
+		if err == nil && n >= 1 && n <= len(pkgs) {
+			return pkgs[n-1], nil
+		}
+	}
+}
+
+/* ----- */
+
+func FindModuleRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no go.mod found")
+		}
+		dir = parent
+	}
+}
+
+// Creates a lockfile with our pid, replacing one left behind by a dead golr
+func LockModule(root string) (string, error) {
+	path := filepath.Join(root, ".golr.lock")
+
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+			f.Close()
+			return path, err
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && pid == os.Getpid() {
+			// We re-executed ourselves
+			return path, nil
+		}
+		if err == nil && ProcessAlive(pid) {
+			return "", fmt.Errorf("golr is already running for %s (pid %d)", root, pid)
+		}
+		if err := os.Remove(path); err != nil {
+			return "", err
+		}
+	}
+}
+
+/* ----- */
+
+var knownOS = []string{"aix", "android", "darwin", "dragonfly", "freebsd", "illumos", "ios",
+	"js", "linux", "netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows"}
+
+// Splits off an "os:" prefix naming one of the known platforms
+func CutOSPrefix(value string) (string, string, bool) {
+	for _, name := range knownOS {
+		if rest, ok := strings.CutPrefix(value, name+":"); ok {
+			return name, rest, true
+		}
+	}
+	return "", value, false
+}
+
+// A command flag, repeated with an "os:" prefix for platform specific variants
+type OSCommand struct {
+	any string
+	byOS map[string]string
+}
+
+func (c *OSCommand) UnmarshalFlag(value string) error {
+	if name, command, ok := CutOSPrefix(value); ok {
+		if c.byOS == nil {
+			c.byOS = make(map[string]string)
+		}
+		c.byOS[name] = command
+		return nil
+	}
+	c.any = value
+	return nil
+}
+
+func (c OSCommand) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// Returns the command for the OS we're running on
+func (c OSCommand) String() string {
+	if command, ok := c.byOS[runtime.GOOS]; ok {
+		return command
+	}
+	return c.any
+}
+
+/* ----- */
+
+func ShellArgv(command string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C", command}
+	}
+	return []string{"sh", "-c", command}
+}
+
+func ShellCommand(ctx context.Context, command string) *exec.Cmd {
+	argv := ShellArgv(command)
+	return exec.CommandContext(ctx, argv[0], argv[1:]...)
+}
+
+// The child is idle when the check URL answers 2xx or the check command exits 0
+func IsBusy(check string) bool {
+	if strings.HasPrefix(check, "http://") || strings.HasPrefix(check, "https://") {
+		client := http.Client{Timeout: 2 * time.Second}
+		resp, err := client.Get(check)
+		if err != nil {
+			return true
+		}
+		resp.Body.Close()
+		return resp.StatusCode < 200 || resp.StatusCode > 299
+	}
+
+	ctx, cancel := context.WithTimeout(rootContext, 5 * time.Second)
+	defer cancel()
+	return ShellCommand(ctx, check).Run() != nil
+}
+
+/* ----- */
+
+// What --webhook posts after each build
+type WebhookEvent struct {
+	Project string `json:"project"`
+	Success bool `json:"success"`
+	Error string `json:"error,omitempty"`
+	DurationMs int64 `json:"duration_ms"`
+	ChangedFile string `json:"changed_file"`
+	Time string `json:"time"`
+}
+
+// Runs in its own goroutine, a slow or failing webhook must not hold up the loop
+func PostWebhook(url string, secret string, event WebhookEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("Webhook failed: %s\n", err)
+		return
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		fmt.Printf("Webhook failed: %s\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(secret) != 0 {
+		req.Header.Set("X-Golr-Secret", secret)
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("Webhook failed: %s\n", err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		fmt.Printf("Webhook failed: %s %s\n", url, resp.Status)
+	}
+}
+
+/* ----- */
+
+// Sends each cycle and child run as spans to an OTLP/HTTP collector, in the
+// protocol's JSON encoding
+type Otel struct {
+	url string
+	mutex sync.Mutex
+	failing bool
+}
+
+func NewOtel(endpoint string) *Otel {
+	o := Otel{}
+	o.url = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	return &o
+}
+
+// One step of a cycle
+type PhaseTime struct {
+	name string
+	start time.Time
+	end time.Time
+}
+
+type OtelSpan struct {
+	TraceID string `json:"traceId"`
+	SpanID string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId,omitempty"`
+	Name string `json:"name"`
+	Kind int `json:"kind"`
+	Start string `json:"startTimeUnixNano"`
+	End string `json:"endTimeUnixNano"`
+	Attributes []OtelAttribute `json:"attributes,omitempty"`
+	Status OtelStatus `json:"status"`
+}
+
+type OtelAttribute struct {
+	Key string `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type OtelStatus struct {
+	Code int `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func StringAttr(key string, value string) OtelAttribute {
+	return OtelAttribute{key, map[string]any{"stringValue": value}}
+}
+
+func BoolAttr(key string, value bool) OtelAttribute {
+	return OtelAttribute{key, map[string]any{"boolValue": value}}
+}
+
+func IntAttr(key string, value int64) OtelAttribute {
+	// 64 bit integers are strings in OTLP JSON
+	return OtelAttribute{key, map[string]any{"intValue": strconv.FormatInt(value, 10)}}
+}
+
+func NewSpan(traceID string, name string, start time.Time, end time.Time, err error) OtelSpan {
+	span := OtelSpan{}
+	span.TraceID = traceID
+	span.SpanID = RandomHex(8)
+	span.Name = name
+	span.Kind = 1
+	span.Start = strconv.FormatInt(start.UnixNano(), 10)
+	span.End = strconv.FormatInt(end.UnixNano(), 10)
+	span.Status.Code = 1
+	if err != nil {
+		span.Status.Code = 2
+		span.Status.Message = err.Error()
+	}
+	return span
+}
+
+func RandomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// A build cycle, with a child span for each step that ran. The failed step
+// gets the error.
+func (o *Otel) cycle(target string, start time.Time, phases []PhaseTime, phase string, changed string, err error) {
+	traceID := RandomHex(16)
+	root := NewSpan(traceID, "cycle", start, time.Now(), err)
+	root.Attributes = []OtelAttribute{
+		StringAttr("golr.target", target),
+		BoolAttr("golr.success", err == nil),
+		IntAttr("golr.duration_ms", time.Since(start).Milliseconds()),
+		StringAttr("golr.changed_file", changed),
+	}
+	if err != nil {
+		root.Attributes = append(root.Attributes, StringAttr("golr.phase", phase))
+	}
+
+	spans := []OtelSpan{root}
+	for _, ph := range phases {
+		var phaseErr error
+		if ph.name == phase {
+			phaseErr = err
+		}
+		span := NewSpan(traceID, ph.name, ph.start, ph.end, phaseErr)
+		span.ParentSpanID = root.SpanID
+		span.Attributes = []OtelAttribute{StringAttr("golr.target", target)}
+		spans = append(spans, span)
+	}
+	go o.export(spans)
+}
+
+// A child's run, from its start to its exit. One golr killed for a rebuild
+// or a restart didn't fail, whatever its exit status says.
+func (o *Otel) run(target string, start time.Time, ps *os.ProcessState, killed bool) {
+	var err error
+	if !ps.Success() && !killed {
+		err = fmt.Errorf("%s", ps)
+	}
+	span := NewSpan(RandomHex(16), "run", start, time.Now(), err)
+	span.Attributes = []OtelAttribute{
+		StringAttr("golr.target", target),
+		IntAttr("golr.exit_code", int64(ExitCode(ps))),
+		BoolAttr("golr.killed", killed),
+	}
+	go o.export([]OtelSpan{span})
+}
+
+// Runs in its own goroutine. An unreachable collector is reported once, not
+// for every cycle, and never holds anything up.
+func (o *Otel) export(spans []OtelSpan) {
+	commit, _ := GitCommit()
+	for i := range spans {
+		if len(commit) != 0 {
+			spans[i].Attributes = append(spans[i].Attributes, StringAttr("git.commit", commit))
+		}
+	}
+
+	body := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []OtelAttribute{StringAttr("service.name", "golr")},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "golr"},
+				"spans": spans,
+			}},
+		}},
+	}
+	data, err := json.Marshal(body)
+	if err == nil {
+		var resp *http.Response
+		client := http.Client{Timeout: 5 * time.Second}
+		resp, err = client.Post(o.url, "application/json", bytes.NewReader(data))
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = fmt.Errorf("%s returned %s", o.url, resp.Status)
+			}
+		}
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if err != nil && !o.failing {
+		fmt.Printf("Can't send spans to the collector, not reporting again until it works: %s\n", err)
+	} else if err != nil {
+		Trace("otel: %s", err)
+	} else if o.failing {
+		fmt.Printf("Sending spans to %s again\n", o.url)
+	}
+	o.failing = err != nil
+}
+
+// Only on the first ready child of the session, later reloads reuse the tab
+var browserOpened bool
+
+func OpenBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// Sends one request to the freshly started child, retrying until it's listening
+func Warmup(url string, method string, body string) {
+	client := http.Client{Timeout: 30 * time.Second}
+	deadline := time.Now().Add(30 * time.Second)
+
+	for time.Now().Before(deadline) {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			fmt.Printf("Warmup failed: %s\n", err)
+			return
+		}
+
+		startTime := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		fmt.Printf("Warmup: %s %s -> %s in %s\n", method, url, resp.Status, time.Since(startTime))
+		return
+	}
+
+	fmt.Printf("Warmup failed: no response from %s\n", url)
+}
+
+/* ----- */
+
+// Prints the coverage merged over all runs so far and writes it as a profile
+func CoverReport(dir string, profile string) {
+	cmd := exec.Command("go", "tool", "covdata", "percent", "-i", dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Printf("Coverage report failed:\n%s\n", out)
+		return
+	}
+	fmt.Printf("Coverage (all runs):\n%s", out)
+
+	cmd = exec.Command("go", "tool", "covdata", "textfmt", "-i", dir, "-o", profile)
+	out, err = cmd.CombinedOutput()
+	if err != nil {
+		fmt.Printf("Writing %s failed:\n%s\n", profile, out)
+	}
+}
+
+/* ----- */
+
+var timingHeader = []string{"timestamp", "duration_ms", "success", "changed_file", "packages_recompiled"}
+
+// Appends a row, writing the header first if the file is new or empty
+func AppendCSV(path string, header []string, row []string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	if fi.Size() == 0 {
+		w.Write(header)
+	}
+	w.Write(row)
+	w.Flush()
+	return w.Error()
+}
+
+// Writes a build's output to a new file in dir, keeping only the newest keep files
+func SaveBuildLog(dir string, keep int, header string, out []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Names sort by time
+	name := "build-" + time.Now().Format("20060102-150405.000") + ".log"
+	data := append([]byte(header+"\n\n"), out...)
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		return err
+	}
+
+	logs, err := filepath.Glob(filepath.Join(dir, "build-*.log"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(logs)
+	for len(logs) > keep {
+		os.Remove(logs[0])
+		logs = logs[1:]
+	}
+	return nil
+}
+
+// Copies the files matching globs into a new set directory under dir, keeping
+// only the newest keep sets. Relative paths are kept inside the set.
+func CollectArtifacts(dir string, keep int, globs []string) (string, int, error) {
+	var files []string
+	for _, glob := range globs {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return "", 0, fmt.Errorf("bad pattern %q: %w", glob, err)
+		}
+		files = append(files, matches...)
+	}
+
+	// Names sort by time
+	set := filepath.Join(dir, "cycle-" + time.Now().Format("20060102-150405.000"))
+	count := 0
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		name := filepath.Clean(f)
+		if filepath.IsAbs(name) || strings.HasPrefix(name, "..") {
+			name = filepath.Base(name)
+		}
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return set, count, err
+		}
+		path := filepath.Join(set, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return set, count, err
+		}
+		if err := os.WriteFile(path, data, fi.Mode().Perm()); err != nil {
+			return set, count, err
+		}
+		count++
+	}
+	if count == 0 {
+		return set, 0, nil
+	}
+
+	sets, err := filepath.Glob(filepath.Join(dir, "cycle-*"))
+	if err != nil {
+		return set, count, err
+	}
+	sort.Strings(sets)
+	for len(sets) > keep {
+		os.RemoveAll(sets[0])
+		sets = sets[1:]
+	}
+	return set, count, nil
+}
+
+/* ----- */
+
+type ControlRequest struct {
+	Action string
+	Target string
+	Reply chan ControlReply
+}
+
+type ControlReply struct {
+	Text string
+	Err error
+}
+
+// The control address is recorded here so "golr ctl" can find it
+const controlAddrFile = ".golr-control"
+
+// Serves POST /reload/{target}, /restart/{target}, /pause, /resume and
+// GET /status, handing requests to the event loop
+func StartControlServer(addr string, rchan chan ControlRequest) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	handle := func(action string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			req := ControlRequest{action, r.PathValue("target"), make(chan ControlReply, 1)}
+			rchan <- req
+			reply := <-req.Reply
+			if reply.Err != nil {
+				http.Error(w, reply.Err.Error(), http.StatusNotFound)
+				return
+			}
+			if len(reply.Text) != 0 {
+				fmt.Fprint(w, reply.Text)
+			} else {
+				fmt.Fprintf(w, "ok\n")
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /reload/{target}", handle("reload"))
+	mux.HandleFunc("POST /restart/{target}", handle("restart"))
+	mux.HandleFunc("POST /pause", handle("pause"))
+	mux.HandleFunc("POST /resume", handle("resume"))
+	mux.HandleFunc("GET /status", handle("status"))
+
+	fmt.Printf("Control server on %s\n", ln.Addr())
+	if err := WriteFileAtomic(controlAddrFile, []byte(ln.Addr().String()+"\n"), 0644); err != nil {
+		fmt.Printf("Warning: can't write %s: %s\n", controlAddrFile, err)
+	}
+	go http.Serve(ln, mux)
+	return nil
+}
+
+// Commands from a parent process on golr's own stdin, one per line, the
+// answers go to stdout: reload|restart [target], status, pause, resume, quit
+func ReadControlStdin(rchan chan ControlRequest) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if !slices.Contains([]string{"reload", "restart", "status", "pause", "resume", "quit"}, fields[0]) {
+			fmt.Printf("error: unknown command %q\n", fields[0])
+			continue
+		}
+
+		req := ControlRequest{fields[0], "", make(chan ControlReply, 1)}
+		if len(fields) > 1 {
+			req.Target = fields[1]
+		}
+		rchan <- req
+		reply := <-req.Reply
+		if reply.Err != nil {
+			fmt.Printf("error: %s\n", reply.Err)
+		} else if len(reply.Text) != 0 {
+			fmt.Print(reply.Text)
+		} else {
+			fmt.Printf("ok\n")
+		}
+	}
+}
+
+// Implements "golr ctl [--connect addr] <command> [target]" against a running golr
+func CtlMain(args []string) error {
+	addr := ""
+	if len(args) > 0 && args[0] == "--connect" {
+		if len(args) < 2 {
+			return fmt.Errorf("--connect needs an address")
+		}
+		addr, args = args[1], args[2:]
+	} else if len(args) > 0 && strings.HasPrefix(args[0], "--connect=") {
+		addr, args = strings.TrimPrefix(args[0], "--connect="), args[1:]
+	}
+	if len(addr) == 0 {
+		data, err := os.ReadFile(controlAddrFile)
+		if err != nil {
+			return fmt.Errorf("no running golr found (%s), use --connect", err)
+		}
+		addr = strings.TrimSpace(string(data))
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: golr ctl [--connect addr] reload|restart|status|pause|resume [target]")
+	}
+	method, path := "POST", ""
+	switch args[0] {
+	case "reload", "restart":
+		if len(args) != 2 {
+			return fmt.Errorf("%s needs a target", args[0])
+		}
+		path = "/" + args[0] + "/" + url.PathEscape(args[1])
+	case "pause", "resume":
+		path = "/" + args[0]
+	case "status":
+		method, path = "GET", "/status"
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	req, err := http.NewRequest(method, "http://"+addr+path, nil)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", strings.TrimSpace(string(body)))
+	}
+	fmt.Print(string(body))
+	return nil
+}
+
+/* ----- */
+
+// Talks the LiveReload protocol to browsers, so asset changes reload the page
+// without restarting the child
+type LiveReload struct {
+	mutex sync.Mutex
+	conns map[net.Conn]bool
+}
+
+const liveReloadProtocol = "http://livereload.com/protocols/official-7"
+
+// For pages without the browser extension, include <script src="http://localhost:35729/livereload.js">
+const liveReloadScript = `(function() {
+	var ws = new WebSocket("ws://%s/livereload");
+	ws.onopen = function() {
+		ws.send(JSON.stringify({command: "hello", protocols: ["` + liveReloadProtocol + `"]}));
+	};
+	ws.onmessage = function(e) {
+		if (JSON.parse(e.data).command === "reload") {
+			location.reload();
+		}
+	};
+})();
+`
+
+func StartLiveReload(addr string) (*LiveReload, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	lr := LiveReload{}
+	lr.conns = make(map[net.Conn]bool)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /livereload", lr.serve)
+	mux.HandleFunc("GET /livereload.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		fmt.Fprintf(w, liveReloadScript, r.Host)
+	})
+
+	fmt.Printf("LiveReload server on %s\n", ln.Addr())
+	go http.Serve(ln, mux)
+	return &lr, nil
+}
+
+func (lr *LiveReload) serve(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || len(key) == 0 {
+		http.Error(w, "websocket only", http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't hijack", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", WebsocketAccept(key))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	lr.mutex.Lock()
+	lr.conns[conn] = true
+	lr.mutex.Unlock()
+	defer func() {
+		lr.mutex.Lock()
+		delete(lr.conns, conn)
+		lr.mutex.Unlock()
+	}()
+
+	for {
+		opcode, payload, err := ReadWebsocketFrame(rw.Reader)
+		if err != nil || opcode == 0x8 {
+			return
+		}
+		if opcode == 0x9 {
+			lr.send(conn, 0xa, payload)
+			continue
+		}
+		var msg struct {
+			Command string `json:"command"`
+		}
+		if opcode == 0x1 && json.Unmarshal(payload, &msg) == nil && msg.Command == "hello" {
+			hello, _ := json.Marshal(map[string]any{
+				"command": "hello",
+				"protocols": []string{liveReloadProtocol},
+				"serverName": "golr",
+			})
+			lr.send(conn, 0x1, hello)
+		}
+	}
+}
+
+func (lr *LiveReload) send(conn net.Conn, opcode byte, payload []byte) {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	WriteWebsocketFrame(conn, opcode, payload)
+}
+
+// Tells every connected browser to reload, CSS can be swapped in place
+func (lr *LiveReload) reload(path string) {
+	msg, _ := json.Marshal(map[string]any{
+		"command": "reload",
+		"path": path,
+		"liveCSS": true,
+	})
+
+	lr.mutex.Lock()
+	conns := make([]net.Conn, 0, len(lr.conns))
+	for conn := range lr.conns {
+		conns = append(conns, conn)
+	}
+	lr.mutex.Unlock()
+
+	if len(conns) != 0 {
+		fmt.Printf("LiveReload: reloading %d browser(s)\n", len(conns))
+	}
+	for _, conn := range conns {
+		lr.send(conn, 0x1, msg)
+	}
+}
+
+func WebsocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Reads one frame, browsers mask theirs. LiveReload messages are small and
+// never fragmented.
+func ReadWebsocketFrame(r io.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0f
+	n := uint64(head[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	} else if n == 127 {
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > 1<<20 {
+		return 0, nil, fmt.Errorf("websocket frame too large")
+	}
+
+	var mask [4]byte
+	masked := head[1]&0x80 != 0
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+func WriteWebsocketFrame(w io.Writer, opcode byte, payload []byte) error {
+	head := []byte{0x80 | opcode}
+	n := len(payload)
+	if n < 126 {
+		head = append(head, byte(n))
+	} else if n < 1<<16 {
+		head = append(head, 126, byte(n>>8), byte(n))
+	} else {
+		head = append(head, 127)
+		head = binary.BigEndian.AppendUint64(head, uint64(n))
+	}
+	_, err := w.Write(append(head, payload...))
+	return err
+}
+
+/* ----- */
+
+// Builds and runs on another machine, the sources are synced there with rsync
+type Remote struct {
+	host string
+	dir string
+}
+
+// Parses [user@]host:path
+func ParseRemote(spec string) (*Remote, error) {
+	host, dir, ok := strings.Cut(spec, ":")
+	if !ok || len(host) == 0 || len(dir) == 0 {
+		return nil, fmt.Errorf("bad --ssh %q, expected user@host:path", spec)
+	}
+
+	r := Remote{}
+	r.host = host
+	r.dir = dir
+	return &r, nil
+}
+
+func (r *Remote) sync(ctx context.Context, exclude string) error {
+	cmd := exec.CommandContext(ctx, "rsync", "-az", "--delete", "--exclude", ".git", "--exclude", exclude,
+		"./", r.host+":"+r.dir+"/")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Printf("Sync to %s:%s failed:\n%s\n", r.host, r.dir, out)
+	}
+	return err
+}
+
+// Source paths as seen from the synced directory, auto-detected ones are absolute
+func (r *Remote) paths(srcs []string) ([]string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	rel := make([]string, 0, len(srcs))
+	for _, f := range srcs {
+		if !filepath.IsAbs(f) {
+			rel = append(rel, f)
+			continue
+		}
+		path, err := filepath.Rel(wd, f)
+		if err != nil || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s is outside the directory synced to %s", f, r.host)
+		}
+		rel = append(rel, "./"+filepath.ToSlash(path))
+	}
+	return rel, nil
+}
+
+// The remote command line, run from the synced directory
+func (r *Remote) script(name string, args ...string) string {
+	words := make([]string, 0, len(args)+1)
+	words = append(words, ShellQuote(name))
+	for _, arg := range args {
+		words = append(words, ShellQuote(arg))
+	}
+	return "cd " + ShellQuote(r.dir) + " && exec " + strings.Join(words, " ")
+}
+
+func (r *Remote) command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "ssh", r.host, r.script(name, args...))
+}
+
+// A forced tty makes the remote process go away along with the local ssh
+func (r *Remote) argv(name string, args ...string) []string {
+	return []string{"ssh", "-tt", r.host, r.script(name, args...)}
+}
+
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+/* ----- */
+
+type HealthResult struct {
+	Proc *os.Process
+	Err error
+}
+
+type HealthCheck struct {
+	url string
+	addr string
+	file string
+	interval time.Duration
+	timeout time.Duration
+	retries int
+	backoff float64
+}
+
+func NewHealthCheck(url string, addr string, file string) *HealthCheck {
+	h := HealthCheck{}
+	h.url = url
+	h.addr = addr
+	h.file = file
+	return &h
+}
+
+func (h *HealthCheck) probe() error {
+	if len(h.file) != 0 {
+		_, err := os.Stat(h.file)
+		return err
+	}
+	if len(h.url) != 0 {
+		client := http.Client{Timeout: h.timeout}
+		resp, err := client.Get(h.url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("%s returned %s", h.url, resp.Status)
+		}
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", h.addr, h.timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Polls until the probe succeeds or retries run out, reporting on hchan
+func (h *HealthCheck) run(ctx context.Context, proc *os.Process, hchan chan HealthResult) {
+	interval := h.interval
+	err := fmt.Errorf("no attempts made")
+
+	for attempt := 0; attempt <= h.retries; attempt++ {
+		select {
+		case <-ctx.Done():
+			hchan <- HealthResult{proc, context.Cause(ctx)}
+			return
+		case <-time.After(interval):
+		}
+
+		if err = h.probe(); err == nil {
+			break
+		}
+		interval = time.Duration(float64(interval) * h.backoff)
+	}
+
+	hchan <- HealthResult{proc, err}
+}
+
+/* ----- */
+
+// Accepts names like TERM, SIGTERM or sigterm
+func ParseSignal(name string) (os.Signal, error) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	if sig, ok := signalNames[name]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unknown signal %s", name)
+}
+
+/* ----- */
+
+func NewCycleContext(start time.Time, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithDeadline(rootContext, start.Add(timeout))
+	}
+	return context.WithCancel(rootContext)
+}
+
+// Everything golr runs is canceled at --deadline
+var rootContext = context.Background()
+
+// As timeout(1) would exit
+const deadlineExitCode = 124
+
+// A duration from now like 10m, a time today like 17:30, or an RFC 3339 time
+func ParseDeadline(spec string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(spec); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, spec); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.ParseInLocation(layout, spec, now.Location()); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad --deadline %q, expected e.g. 10m, 17:30 or 2006-01-02T15:04:05Z", spec)
+}
+
+func DeadlineExit(msg string) {
+	fmt.Println("*** Deadline reached:", msg)
+	RunExitHook()
+	os.Exit(deadlineExitCode)
+}
+
+/* ----- */
+
+const reexecGuard = 5 * time.Second
+
+const idleScanInterval = 5 * time.Second
+
+// A crashed child that ran at least this long had recovered, its restarts count from zero
+const stableRunTime = 30 * time.Second
+
+var stateNames = []string{"building", "running", "deferring", "killing", "cooling", "exiting"}
+
+const (
+	building = iota
+	running = iota
+	deferring = iota
+	killing = iota
+	cooling = iota
+	exiting = iota
+)
+
+/* ----- */
+
+type ProjectConfig struct {
+	Name string `json:"name"`
+	Srcs []string `json:"srcs"`
+	OutFile string `json:"outfile"`
+	Args []string `json:"args"`
+	Env []string `json:"env"`
+	EnvFile string `json:"env_file"`
+	WorkDir string `json:"workdir"`
+	Dirs []string `json:"dirs"`
+}
+
+func (cfg *ProjectConfig) label() string {
+	if len(cfg.Name) != 0 {
+		return cfg.Name + ": "
+	}
+	return ""
+}
+
+func LoadProjects(path string) ([]ProjectConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make([]ProjectConfig, 0)
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("%s: no projects", path)
+	}
+
+	return configs, nil
+}
+
+// Named sets of golr flags, e.g. {"full": ["--test", "--lint"]}
+func LoadProfiles(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string][]string)
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return profiles, nil
+}
+
+/* ----- */
+
+type Project struct {
+	name string
+	config ProjectConfig
+	scanner *Scanner
+	builder *Builder
+	runner *Runner
+	health *HealthCheck
+	hchan chan HealthResult
+	healthCancel context.CancelFunc
+	checkCancel context.CancelFunc
+	pendingConfig *ProjectConfig
+	stopped bool
+	warmed bool
+	livereload *LiveReload
+	state int
+	restartOnly bool
+	holdStart bool
+	scanned bool
+	preBuild []*Hook
+	postBuild []*Hook
+	changeAt time.Time
+	leadingUntil time.Time
+	restartCodes map[int]bool
+	restartExclude bool
+	restarts int
+	backoff time.Duration
+	deferStart time.Time
+	cycleStart time.Time
+	killTime time.Time
+	lastBuild time.Time
+	lastGood time.Time
+	otel *Otel
+	phases []PhaseTime
+	overrides []*BuildOverride
+	runStart time.Time
+}
+
+func NewProject(cfg ProjectConfig, opts *Flags, pchan chan PStateErr, hchan chan HealthResult) (*Project, error) {
+	outfile, err := filepath.Abs(cfg.OutFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Change scanner
+	watched := cfg.Srcs
+	if opts.WatchBinary {
+		// The binary is produced by an external build, restart when it's replaced
+		watched = []string{outfile}
+	}
+	scanner := NewScanner(watched, cfg.Dirs)
+	scanner.showChanges = opts.ShowChanges
+	if !opts.WatchBinary {
+		// The binary going away while it's replaced is expected
+		scanner.emptyRecheck = opts.EmptyRecheck
+	}
+	if len(opts.SinceCommit) != 0 && !opts.WatchBinary {
+		if err := scanner.watchSinceCommit(opts.SinceCommit); err != nil {
+			fmt.Printf("Can't use --since-commit, watching all sources: %s\n", err)
+		}
+	}
+	if opts.Git {
+		if err := scanner.watchGit(opts.GitSettle); err != nil {
+			return nil, err
+		}
+	}
+	if opts.WatchDeps {
+		if err := scanner.watchDeps(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Executable builder, explicit flags come last so they win over presets
+	names := append([]string{}, opts.Presets...)
+	if opts.Reproducible {
+		names = append(names, "reproducible")
+	}
+	buildFlags, err := PresetFlags(names)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BuildP > 0 {
+		buildFlags = append(buildFlags, "-p="+strconv.Itoa(opts.BuildP))
+	}
+	buildFlags = append(buildFlags, opts.BuildFlags...)
+	builder := NewBuilder(outfile, cfg.Srcs, buildFlags)
+	if opts.CleanEnv {
+		builder.keepEnv = append(builder.keepEnv, cleanEnv...)
+		if runtime.GOOS == "windows" {
+			builder.keepEnv = append(builder.keepEnv, cleanEnvWindows...)
+		}
+		builder.keepEnv = append(builder.keepEnv, opts.KeepEnv...)
+	}
+	builder.cacheDir = opts.CacheDir
+	builder.json = opts.BuildJSON
+	builder.touch = opts.TouchOutput
+	builder.explain = opts.ExplainBuild
+	builder.test = opts.TestBinary
+	builder.cover = opts.Cover
+	if len(opts.FailOnOutput) != 0 {
+		builder.failOn, err = regexp.Compile(opts.FailOnOutput)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !opts.NoValidate && !opts.WatchBinary {
+		if err := builder.validate(); err != nil {
+			return nil, fmt.Errorf("Invalid build flags")
+		}
+	}
+
+	// Executable runner
+	args := ChildArgs(cfg, opts)
+	stdinFile := opts.StdinFile
+	if opts.ControlStdin && len(stdinFile) == 0 {
+		// Our stdin carries commands, the child must not read them
+		stdinFile = os.DevNull
+	}
+	runner := NewRunner(outfile, args, stdinFile, pchan)
+	runner.procName = opts.ProcName
+	runner.argsFile = opts.ArgsFile
+	runner.argsCmd = opts.RunArgsCmd.String()
+	runner.pidfile = opts.PidFile
+	if len(opts.PidFile) != 0 && len(cfg.Name) != 0 {
+		// Each project gets its own, app.pid becomes app-api.pid
+		ext := filepath.Ext(opts.PidFile)
+		runner.pidfile = strings.TrimSuffix(opts.PidFile, ext) + "-" + cfg.Name + ext
+	}
+	if len(opts.SSH) != 0 {
+		remote, err := ParseRemote(opts.SSH)
+		if err != nil {
+			return nil, err
+		}
+		builder.remote = remote
+		runner.remote = remote
+	}
+	runner.env = EnvLayers(cfg, opts)
+	if opts.LineBuffered {
+		runner.envHints = lineBufferedEnv
+	}
+	runner.dir = opts.WorkDir
+	if len(cfg.WorkDir) != 0 {
+		runner.dir = cfg.WorkDir
+	}
+	runner.sys, err = ChildSysProcAttr(opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Netns && runtime.GOOS == "linux" {
+		runner.sys = NetnsSysProcAttr(runner.sys)
+		runner.netns = true
+	}
+	if execTemplate := opts.ExecTemplate.String(); len(execTemplate) != 0 {
+		runner.execTemplate = execTemplate
+		runner.sys = GroupSysProcAttr(runner.sys)
+	}
+	if len(opts.MemoryLimit) != 0 && runtime.GOOS == "linux" {
+		limit, err := ParseSize(opts.MemoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		name := cfg.Name
+		if len(name) == 0 {
+			name = filepath.Base(outfile)
+		}
+		runner.cgroup, err = NewMemoryCgroup(name, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p := Project{}
+	p.name = cfg.Name
+	p.config = cfg
+	p.scanner = scanner
+	p.builder = builder
+	p.runner = runner
+	p.hchan = hchan
+	p.state = building
+	for _, spec := range opts.PreBuild {
+		p.preBuild = append(p.preBuild, ParseHook(spec))
+	}
+	for _, spec := range opts.PostBuild {
+		p.postBuild = append(p.postBuild, ParseHook(spec))
+	}
+	for _, h := range slices.Concat(p.preBuild, p.postBuild) {
+		// A hook's files need watching too, e.g. the .sql of when=*.sql:sqlc generate
+		if len(h.when) != 0 && h.forThisOS() && !opts.WatchBinary {
+			p.scanner.extraGlobs = append(p.scanner.extraGlobs, h.when)
+		}
+	}
+	for _, spec := range opts.BuildOverrides {
+		o, err := ParseBuildOverride(spec)
+		if err != nil {
+			return nil, err
+		}
+		p.overrides = append(p.overrides, o)
+		if !opts.WatchBinary {
+			p.scanner.extraGlobs = append(p.scanner.extraGlobs, o.globs...)
+		}
+	}
+	if len(opts.RestartOnCodes) != 0 {
+		p.restartCodes, p.restartExclude, err = ParseExitCodes(opts.RestartOnCodes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.SkipInitialIfFresh && p.fresh() {
+		fmt.Printf("%s is newer than its sources, skipping the first build\n", runner.outfile)
+		p.restartOnly = true
+	}
+
+	// Readiness check
+	if len(opts.HealthURL) != 0 || len(opts.HealthAddr) != 0 || len(opts.ReadyFile) != 0 {
+		p.health = NewHealthCheck(opts.HealthURL, opts.HealthAddr, opts.ReadyFile)
+		p.health.interval = opts.HealthInterval
+		p.health.timeout = opts.HealthTimeout
+		p.health.retries = opts.HealthRetries
+		p.health.backoff = opts.HealthBackoff
+	} else if len(opts.ReadyLog) != 0 {
+		runner.readyLog, err = regexp.Compile(opts.ReadyLog)
+		if err != nil {
+			return nil, err
+		}
+		runner.hchan = hchan
+	}
+
+	return &p, nil
+}
+
+func ChildArgs(cfg ProjectConfig, opts *Flags) []string {
+	if !opts.TestBinary {
+		return cfg.Args
+	}
+	args := make([]string, 0, 10)
+	args = append(args, "-test.v")
+	if len(opts.TestRun) != 0 {
+		args = append(args, "-test.run", opts.TestRun)
+	}
+	if opts.Cover {
+		// Raw coverage data from every run accumulates in one directory
+		args = append(args, "-test.gocoverdir="+opts.CoverDir)
+	}
+	args = append(args, opts.TestFlags...)
+	return append(args, cfg.Args...)
+}
+
+// Project settings come after the global ones and override them
+func EnvLayers(cfg ProjectConfig, opts *Flags) []EnvLayer {
+	layers := make([]EnvLayer, 0, 2)
+	if len(opts.EnvFile) != 0 || len(opts.Env) != 0 {
+		layers = append(layers, EnvLayer{opts.EnvFile, opts.Env})
+	}
+	if len(cfg.EnvFile) != 0 || len(cfg.Env) != 0 {
+		layers = append(layers, EnvLayer{cfg.EnvFile, cfg.Env})
+	}
+	return layers
+}
+
+// What an edit to a project's entry needs: nothing, "run" settings that need
+// a restart, "build" settings that need a rebuild, or "identity" changes that
+// need golr itself restarted
+func DiffProjectConfig(old ProjectConfig, cfg ProjectConfig) string {
+	if old.Name != cfg.Name || old.OutFile != cfg.OutFile {
+		return "identity"
+	}
+	if !slices.Equal(old.Srcs, cfg.Srcs) {
+		return "build"
+	}
+	if !slices.Equal(old.Args, cfg.Args) || !slices.Equal(old.Env, cfg.Env) ||
+		old.EnvFile != cfg.EnvFile || old.WorkDir != cfg.WorkDir {
+		return "run"
+	}
+	return ""
+}
+
+// Applies an edited --projects entry, touching the child only when it has to.
+// Between stopping the old child and starting the new one it waits for the start.
+func (p *Project) reconfigure(cfg ProjectConfig, opts *Flags) {
+	if p.state == killing || p.state == cooling {
+		Trace("%s: restarting, the edit is applied after the start", p.target())
+		p.pendingConfig = &cfg
+		return
+	}
+	p.pendingConfig = nil
+	change := DiffProjectConfig(p.config, cfg)
+	if len(change) == 0 {
+		return
+	}
+	if change == "identity" {
+		fmt.Printf("%s: name or outfile changed, restart golr to apply\n", p.target())
+		return
+	}
+	if len(cfg.Srcs) == 0 && !opts.WatchBinary {
+		fmt.Printf("%s: no source files, keeping the old settings\n", p.target())
+		return
+	}
+
+	p.scanner.mutex.Lock()
+	if change == "build" && !opts.WatchBinary && len(opts.SinceCommit) == 0 {
+		p.scanner.srcs = cfg.Srcs
+		p.scanner.embeds = FindEmbeds(cfg.Srcs)
+		p.scanner.trackDirs()
+	}
+	p.scanner.mutex.Unlock()
+	p.builder.srcs = cfg.Srcs
+	p.runner.args = ChildArgs(cfg, opts)
+	p.runner.env = EnvLayers(cfg, opts)
+	p.runner.dir = opts.WorkDir
+	if len(cfg.WorkDir) != 0 {
+		p.runner.dir = cfg.WorkDir
+	}
+	p.config = cfg
+
+	if change == "run" {
+		fmt.Printf("%s: run settings changed, restarting\n", p.target())
+		p.restartOnly = true
+		p.runner.reason = "restart"
+		p.reload()
+	} else {
+		fmt.Printf("%s: build settings changed, rebuilding\n", p.target())
+		p.restartOnly = false
+		p.runner.reason = "rebuild"
+		p.reload()
+	}
+}
+
+// Re-reads --projects and applies it to the running projects, matched in order
+func ReloadProjects(path string, projects []*Project, opts *Flags) {
+	configs, err := LoadProjects(path)
+	if err != nil {
+		fmt.Printf("Can't reload %s: %s\n", path, err)
+		return
+	}
+	if len(configs) != len(projects) {
+		fmt.Printf("Projects added or removed in %s, restart golr to apply\n", path)
+		return
+	}
+	fmt.Printf("Reloaded %s\n", path)
+	for i, p := range projects {
+		p.reconfigure(configs[i], opts)
+	}
+}
+
+// Runs --check-all alongside the child and only reports a failure, a newer
+// build cancels a check that's still going
+func (p *Project) checkAllAsync() {
+	if p.checkCancel != nil {
+		p.checkCancel()
+	}
+	ctx, cancel := context.WithCancel(rootContext)
+	p.checkCancel = cancel
+	env := p.builder.env
+	go func() {
+		defer cancel()
+		if err := p.builder.checkAll(ctx, env); err != nil && ctx.Err() == nil {
+			fmt.Println("Module check failed", err)
+		}
+	}()
+}
+
+func (p *Project) build(opts *Flags, stats *Stats) {
+	var err error
+
+	// A --scan-goroutine scan waits for the build
+	p.scanner.mutex.Lock()
+	defer p.scanner.mutex.Unlock()
+
+	p.cycleStart = time.Now()
+	ctx, cancel := NewCycleContext(p.cycleStart, opts.CycleTimeout)
+	defer cancel()
+
+	if opts.RebuildGrace > 0 {
+		p.scanner.grace(p.cycleStart, opts.RebuildGrace, opts.RebuildGraceGlobs)
+	}
+
+	// Tells the build and hooks what triggered them
+	var changed []string
+	p.builder.env, changed = p.scanner.takeChanges()
+
+	// Only for this cycle, the next one starts from the usual flags again
+	p.builder.cycleFlags = nil
+	for _, o := range p.overrides {
+		if o.matches(changed) {
+			fmt.Printf("Build override for %s: %s\n", strings.Join(o.globs, ","), strings.Join(append(slices.Clone(o.env), o.flags...), " "))
+			p.builder.cycleFlags = append(p.builder.cycleFlags, o.flags...)
+			p.builder.env = append(p.builder.env, o.env...)
+		}
+	}
+
+	phase := "build"
+	if p.restartOnly {
+		// The binary is still good, just start it again
+		Trace("%s: no source changes, restarting without a build", p.target())
+		p.restartOnly = false
+	} else if opts.WatchBinary {
+		// Something else builds the binary
+		Trace("%s: --watch-binary, not building", p.target())
+	} else if err = RunHooks(ctx, p.preBuild, p.builder.env, changed); err != nil {
+		phase = "pre-build"
+	} else if opts.FastCheck && p.builder.fastCheck(ctx) != nil {
+		phase = "syntax check"
+		err = fmt.Errorf("syntax errors")
+	} else {
+		err = p.builder.build(ctx)
+		p.timed("build", time.Now().Add(-p.builder.elapsed))
+		stats.addBuild(p.builder.elapsed, err)
+		if len(opts.TimingCSV) != 0 {
+			p.logTiming(opts.TimingCSV, err)
+		}
+		if opts.KeepLogs > 0 {
+			p.saveLog(opts, err)
+		}
+		if len(opts.Webhook) != 0 {
+			p.notify(opts, err)
+		}
+		if err == nil && len(opts.AlsoBuild) != 0 {
+			// Only the native binary runs, a broken cross build is just reported
+			if err := p.builder.crossBuild(ctx, opts.AlsoBuild); err != nil && ctx.Err() == nil {
+				fmt.Println("Extra build failed", err)
+			}
+		}
+		if err == nil && len(opts.InstallTo) != 0 {
+			if err := p.builder.install(opts.InstallTo); err != nil {
+				fmt.Printf("Can't install to %s: %s\n", opts.InstallTo, err)
+			}
+		}
+		if err == nil && len(opts.WriteBuildinfo) != 0 {
+			if err := p.builder.writeBuildInfo(opts.WriteBuildinfo); err != nil {
+				fmt.Printf("Can't write %s: %s\n", opts.WriteBuildinfo, err)
+			}
+		}
+		if err == nil && opts.CheckAll && !opts.CheckAllBlock {
+			// Only the run target has to compile for the child to start
+			p.checkAllAsync()
+		} else if err == nil && opts.CheckAll {
+			phase = "check-all"
+			start := time.Now()
+			err = p.builder.checkAll(ctx, p.builder.env)
+			p.timed(phase, start)
+		}
+		if err == nil && opts.Lint {
+			phase = "lint"
+			start := time.Now()
+			err = p.builder.lint(ctx, opts.LintCmd.String())
+			p.timed(phase, start)
+		}
+		if err == nil && (opts.Test || opts.TestRace) {
+			phase = "test"
+			start := time.Now()
+			err = p.builder.runTests(ctx, opts.TestRace)
+			p.timed(phase, start)
+		}
+		if err == nil && len(p.postBuild) != 0 {
+			phase = "post-build"
+			start := time.Now()
+			err = RunHooks(ctx, p.postBuild, p.builder.env, changed)
+			p.timed(phase, start)
+		}
+		if err == nil && len(opts.Artifacts) != 0 {
+			// After the hooks, they may be what generates the files
+			set, count, err := CollectArtifacts(opts.ArtifactsDir, opts.KeepArtifacts, opts.Artifacts)
+			if err != nil {
+				fmt.Printf("Can't collect artifacts: %s\n", err)
+			} else if count != 0 {
+				fmt.Printf("Collected %d artifacts in %s\n", count, set)
+			}
+		}
+		p.lastBuild = time.Now()
+		if err == nil {
+			p.lastGood = p.lastBuild
+		}
+	}
+
+	if p.otel != nil && (len(p.phases) != 0 || err != nil) {
+		p.otel.cycle(p.target(), p.cycleStart, p.phases, phase, p.scanner.lastChanged, err)
+	}
+	p.phases = nil
+
+	if ctx.Err() == context.DeadlineExceeded && rootContext.Err() != nil {
+		fmt.Printf("Deadline reached in phase: %s\n", phase)
+	} else if ctx.Err() == context.DeadlineExceeded {
+		fmt.Printf("Cycle timeout (%s) exceeded in phase: %s\n", opts.CycleTimeout, phase)
+	} else if err != nil && phase == "check-all" {
+		fmt.Println("Module check failed", err)
+	} else if err != nil && phase == "lint" {
+		fmt.Println("Lint failed", err)
+	} else if err != nil && phase == "test" {
+		fmt.Println("Tests failed", err)
+	} else if err != nil && phase == "syntax check" {
+		// Already reported
+	} else if err != nil && phase != "build" {
+		fmt.Println("Hook failed", err)
+	} else if err != nil {
+		fmt.Println("Build failed", err)
+	} else if p.holdStart {
+		// Started together with the others once they're all built
+		p.holdStart = false
+		p.state = cooling
+		return
+	} else if time.Since(p.killTime) < p.cooldown(opts) {
+		// Give the old process's resources time to be released
+		fmt.Printf("Cooling down for %s\n", p.cooldown(opts) - time.Since(p.killTime))
+		p.state = cooling
+		return
+	} else {
+		p.start(opts)
+	}
+
+	p.state = running
+}
+
+// Records a step of the cycle that ended just now, for --otel
+func (p *Project) timed(name string, start time.Time) {
+	if p.otel != nil {
+		p.phases = append(p.phases, PhaseTime{name, start, time.Now()})
+	}
+}
+
+func (p *Project) logTiming(path string, err error) {
+	compiled := ""
+	if p.builder.compiled >= 0 {
+		compiled = strconv.Itoa(p.builder.compiled)
+	}
+
+	row := []string{
+		time.Now().Format(time.RFC3339),
+		strconv.FormatInt(p.builder.elapsed.Milliseconds(), 10),
+		strconv.FormatBool(err == nil),
+		p.scanner.lastChanged,
+		compiled,
+	}
+	if err := AppendCSV(path, timingHeader, row); err != nil {
+		fmt.Printf("Can't write %s: %s\n", path, err)
+	}
+}
+
+func (p *Project) notify(opts *Flags, err error) {
+	event := WebhookEvent{}
+	event.Project = p.target()
+	event.Success = err == nil
+	if err != nil {
+		event.Error = err.Error()
+	}
+	event.DurationMs = p.builder.elapsed.Milliseconds()
+	event.ChangedFile = p.scanner.lastChanged
+	event.Time = time.Now().Format(time.RFC3339)
+	go PostWebhook(opts.Webhook, opts.WebhookSecret, event)
+}
+
+func (p *Project) saveLog(opts *Flags, err error) {
+	result := "ok"
+	if err != nil {
+		result = "failed: " + err.Error()
+	}
+	header := fmt.Sprintf("# %s\n# changed: %s\n# duration: %s\n# result: %s\n# go %s",
+		time.Now().Format(time.RFC3339), p.scanner.lastChanged, p.builder.elapsed, result,
+		strings.Join(p.builder.args(), " "))
+	if err := SaveBuildLog(opts.LogsDir, opts.KeepLogs, header, p.builder.output); err != nil {
+		fmt.Printf("Can't save build log: %s\n", err)
+	}
+}
+
+// Whether the binary is newer than every watched file
+func (p *Project) fresh() bool {
+	fi, err := os.Stat(p.runner.outfile)
+	if err != nil {
+		return false
+	}
+	for _, f := range p.scanner.watched() {
+		src, err := os.Stat(f)
+		if err != nil || src.ModTime().After(fi.ModTime()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Name used to address the project, the output file when it has none
+func (p *Project) target() string {
+	if len(p.name) != 0 {
+		return p.name
+	}
+	return filepath.Base(p.runner.outfile)
+}
+
+// With --scan-goroutine the scanner has already looked, this takes its result
+func (p *Project) detect() bool {
+	if !p.scanner.async {
+		return p.scanner.detect()
+	}
+	changed := p.scanned
+	p.scanned = false
+	return changed
+}
+
+func (p *Project) cooled(opts *Flags) bool {
+	return time.Since(p.killTime) >= p.cooldown(opts)
+}
+
+func (p *Project) cooldown(opts *Flags) time.Duration {
+	return max(opts.Cooldown, p.backoff)
+}
+
+func (p *Project) start(opts *Flags) {
+	p.state = running
+
+	if p.health != nil && len(p.health.file) != 0 {
+		// Left over from the previous process, only the new one's counts
+		if err := os.Remove(p.health.file); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Can't remove %s: %s\n", p.health.file, err)
+		}
+	}
+
+	p.runStart = time.Now()
+	p.stopped = false
+	p.warmed = false
+	if err := p.runner.spawn(); err != nil {
+		fmt.Println("Start failed", err)
+	} else if p.health != nil {
+		var hctx context.Context
+		hctx, p.healthCancel = p.readyContext(opts)
+		go p.health.run(hctx, p.runner.proc, p.hchan)
+	} else if p.runner.readyLog != nil {
+		fmt.Printf("Waiting for output matching %s\n", p.runner.readyLog)
+		var rctx context.Context
+		rctx, p.healthCancel = p.readyContext(opts)
+		go func(proc *os.Process) {
+			// The output scanner reports success, this only reports running out of time
+			<-rctx.Done()
+			if err := context.Cause(rctx); err != context.Canceled {
+				p.hchan <- HealthResult{proc, err}
+			}
+		}(p.runner.proc)
+	} else if !p.warmup(opts) {
+		p.ready(opts)
+	}
+}
+
+// Sends --warmup-url before the child is announced, which happens once the
+// result comes back on hchan. False when there's nothing to send.
+func (p *Project) warmup(opts *Flags) bool {
+	if len(opts.WarmupURL) == 0 || p.warmed {
+		return false
+	}
+	p.warmed = true
+	proc := p.runner.proc
+	go func() {
+		Warmup(opts.WarmupURL, opts.WarmupMethod, opts.WarmupBody)
+		p.hchan <- HealthResult{proc, nil}
+	}()
+	return true
+}
+
+// The rest of the cycle's time budget applies to readiness, and --child-ready-timeout
+func (p *Project) readyContext(opts *Flags) (context.Context, context.CancelFunc) {
+	ctx, cancel := NewCycleContext(p.cycleStart, opts.CycleTimeout)
+	if opts.ChildReadyTimeout <= 0 {
+		return ctx, cancel
+	}
+	rctx, rcancel := context.WithTimeoutCause(ctx, opts.ChildReadyTimeout, errNotReady)
+	return rctx, func() {
+		rcancel()
+		cancel()
+	}
+}
+
+func (p *Project) ready(opts *Flags) {
+	if p.livereload != nil {
+		// Pages served by the old process may be stale
+		p.livereload.reload("")
+	}
+	if len(opts.Open) != 0 && !browserOpened {
+		browserOpened = true
+		url := opts.Open
+		if strings.Contains(url, "{port}") {
+			// The child's own PORT, wherever it came from
+			env, _ := p.runner.environ()
+			port := ""
+			for _, kv := range env {
+				if v, ok := strings.CutPrefix(kv, "PORT="); ok {
+					port = v
+				}
+			}
+			if len(port) == 0 {
+				fmt.Printf("Can't open %s: the child has no PORT variable\n", url)
+				return
+			}
+			url = strings.ReplaceAll(url, "{port}", port)
+		}
+		fmt.Printf("Opening %s\n", url)
+		if err := OpenBrowser(url); err != nil {
+			fmt.Printf("Can't open a browser: %s\n", err)
+		}
+	}
+}
+
+func (p *Project) healthDone(opts *Flags, err error) {
+	if p.healthCancel != nil {
+		p.healthCancel()
+	}
+
+	if err == nil && p.warmup(opts) {
+		return
+	}
+	if err == nil {
+		fmt.Printf("Ready\n")
+		p.restarts = 0
+		p.backoff = 0
+		p.ready(opts)
+		return
+	}
+
+	if err == context.DeadlineExceeded && rootContext.Err() != nil {
+		fmt.Printf("Deadline reached in phase: health check\n")
+	} else if err == context.DeadlineExceeded {
+		fmt.Printf("Cycle timeout (%s) exceeded in phase: health check\n", opts.CycleTimeout)
+	} else if err == errNotReady {
+		fmt.Printf("Child not ready after %s\n", opts.ChildReadyTimeout)
+	} else {
+		fmt.Printf("Health check failed: %s\n", err)
+	}
+
+	if opts.HealthPolicy == "restart" && p.backOff(opts) {
+		p.reload()
+	} else if err == errNotReady {
+		// A hung child isn't left running, the next change starts it again
+		fmt.Printf("Stopping the child until the next change\n")
+		p.runner.kill()
+		p.stopped = true
+	}
+}
+
+// Sets up a restart of the same binary after a growing delay, so a child that
+// keeps failing isn't restarted in a tight loop. False once --max-restarts is used up.
+func (p *Project) backOff(opts *Flags) bool {
+	if opts.MaxRestarts > 0 && p.restarts >= opts.MaxRestarts {
+		fmt.Printf("Gave up after %d restarts, waiting for changes\n", p.restarts)
+		return false
+	}
+
+	p.restarts += 1
+	p.backoff = min(time.Second << (p.restarts - 1), 30 * time.Second)
+	fmt.Printf("Restarting in %s\n", p.backoff)
+	p.restartOnly = true
+	p.runner.reason = "crash"
+	return true
+}
+
+// Whether --restart-on-codes asks for the exited child to be started again
+func (p *Project) restartOnExit(code int) bool {
+	if p.restartCodes == nil {
+		return false
+	}
+	if p.restartExclude {
+		return code != 0 && !p.restartCodes[code]
+	}
+	return p.restartCodes[code]
+}
+
+// Parses a list like 137,139 or one of codes not to restart on, like !1,!2
+func ParseExitCodes(spec string) (map[int]bool, bool, error) {
+	codes := make(map[int]bool)
+	exclude := strings.HasPrefix(strings.TrimSpace(spec), "!")
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		value, negated := strings.CutPrefix(item, "!")
+		if negated != exclude {
+			return nil, false, fmt.Errorf("bad --restart-on-codes %q, can't mix codes and !codes", spec)
+		}
+		code, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, false, fmt.Errorf("bad --restart-on-codes %q: %s", spec, err)
+		}
+		codes[code] = true
+	}
+	return codes, exclude, nil
+}
+
+func (p *Project) reload() {
+	if p.healthCancel != nil {
+		p.healthCancel()
+	}
+	if p.runner.kill() {
+		p.killTime = time.Now()
+		p.state = killing
+	} else {
+		p.state = building
+	}
+}
+
+// Returns why the child shouldn't be restarted right now, or an empty string
+func (p *Project) holdReason(opts *Flags) string {
+	if p.runner.proc == nil {
+		return ""
+	}
+
+	if opts.PauseWhenAttached && DebuggerAttached(p.runner.proc.Pid) {
+		return "Debugger attached"
+	}
+
+	if busyCheck := opts.BusyCheck.String(); len(busyCheck) != 0 {
+		if p.state == deferring && time.Since(p.deferStart) > opts.BusyTimeout {
+			fmt.Printf("Child still busy after %s, restarting anyway\n", opts.BusyTimeout)
+			return ""
+		}
+		if IsBusy(busyCheck) {
+			return "Child is busy"
+		}
+	}
+
+	return ""
+}
+
+func AllExited(projects []*Project) bool {
+	for _, p := range projects {
+		if p.state != exiting {
+			return false
+		}
+	}
+	return true
+}
+
+// The binaries don't change, restart whatever is running
+func RestartAll(projects []*Project, reason string) {
+	for _, p := range projects {
+		if p.state == running && p.runner.proc != nil {
+			p.restartOnly = true
+			p.runner.reason = reason
+			p.reload()
+		} else {
+			Trace("%s: not running, nothing to restart", p.target())
+		}
+	}
+}
+
+func FindProjectByTarget(projects []*Project, target string) *Project {
+	for _, p := range projects {
+		if p.target() == target {
+			return p
+		}
+	}
+	return nil
+}
+
+// One line per project, for golr ctl status and SIGUSR1
+func StatusText(projects []*Project, paused bool) string {
+	b := strings.Builder{}
+	if paused {
+		b.WriteString("paused\n")
+	}
+	for _, p := range projects {
+		pid := 0
+		if p.runner.proc != nil {
+			pid = p.runner.proc.Pid
+		}
+		fmt.Fprintf(&b, "%s\t%s\tpid %d\tlast build %s\tlast success %s\n",
+			p.target(), stateNames[p.state], pid, Ago(p.lastBuild), Ago(p.lastGood))
+	}
+	return b.String()
+}
+
+func Ago(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+func FindProjectByScanner(projects []*Project, scanner *Scanner) *Project {
+	for _, p := range projects {
+		if p.scanner == scanner {
+			return p
+		}
+	}
+	return nil
+}
+
+func FindProjectByProc(projects []*Project, proc *os.Process) *Project {
+	for _, p := range projects {
+		if p.runner.proc == proc {
+			return p
+		}
+	}
+	return nil
+}
+
+func FindProject(projects []*Project, runner *Runner) *Project {
+	for _, p := range projects {
+		if p.runner == runner {
+			return p
+		}
+	}
+	return nil
+}
+
+/* ----- */
+
+type ConfigOption struct {
+	Name string `json:"name"`
+	Value interface{} `json:"value"`
+	Source string `json:"source"`
+}
+
+type ConfigProject struct {
+	Name string `json:"name,omitempty"`
+	Srcs []string `json:"srcs"`
+	OutFile string `json:"outfile"`
+	Args []string `json:"args"`
+	Env []string `json:"env"`
+	EnvFiles []string `json:"env_files"`
+	WorkDir string `json:"workdir"`
+	Watched []string `json:"watched"`
+}
+
+type ConfigWatcher struct {
+	Name string `json:"name"`
+	Globs []string `json:"globs"`
+	Command string `json:"command"`
+	Watched []string `json:"watched"`
+}
+
+type Config struct {
+	Options []ConfigOption `json:"options"`
+	Projects []ConfigProject `json:"projects"`
+	Watchers []ConfigWatcher `json:"watchers"`
+}
+
+// Prints the effective configuration and where each option's value came from
+func ShowConfig(parser *flags.Parser, projects []*Project, watchers []*Watcher) {
+	config := Config{}
+
+	for _, group := range parser.Groups() {
+		for _, option := range group.Options() {
+			if option.LongName == "help" {
+				continue
+			}
+			co := ConfigOption{}
+			co.Name = option.LongName
+			co.Value = option.Value()
+			if d, ok := co.Value.(time.Duration); ok {
+				co.Value = d.String()
+			}
+			if option.IsSetDefault() {
+				co.Source = "default"
+			} else if option.IsSet() {
+				co.Source = "flag"
+			} else {
+				co.Source = "unset"
+			}
+			config.Options = append(config.Options, co)
+		}
+	}
+
+	for _, p := range projects {
+		cp := ConfigProject{}
+		cp.Name = p.name
+		cp.Srcs = p.builder.srcs
+		cp.OutFile = p.runner.outfile
+		cp.Args = p.runner.args
+		cp.Env = make([]string, 0)
+		cp.EnvFiles = make([]string, 0)
+		for _, layer := range p.runner.env {
+			if len(layer.file) != 0 {
+				cp.EnvFiles = append(cp.EnvFiles, layer.file)
+			}
+			cp.Env = append(cp.Env, layer.vars...)
+		}
+		cp.WorkDir = p.runner.dir
+		cp.Watched = p.scanner.watched()
+		config.Projects = append(config.Projects, cp)
+	}
+
+	for _, w := range watchers {
+		cw := ConfigWatcher{}
+		cw.Name = w.name
+		cw.Globs = w.globs
+		cw.Command = w.command
+		cw.Watched = w.scanner.watched()
+		config.Watchers = append(config.Watchers, cw)
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		FatalError(err.Error())
+	}
+	fmt.Printf("%s\n", data)
+}
+
+// Prints what a change to the file would trigger
+func ExplainChange(path string, projects []*Project, watchers []*Watcher, contents []*ContentWatch, assets []string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		FatalError(err.Error())
+	}
+	same := func(f string) bool {
+		a, err := filepath.Abs(f)
+		return err == nil && a == abs
+	}
+
+	fmt.Printf("Changing %s:\n", path)
+	found := false
+
+	for _, p := range projects {
+		what := ""
+		for _, f := range p.scanner.srcs {
+			if same(f) {
+				what = "source file"
+			}
+		}
+		for _, f := range p.scanner.embeds {
+			if same(f) {
+				what = "embedded file"
+			}
+		}
+		for _, f := range p.scanner.gitFiles {
+			if same(f) {
+				what = "git metadata"
+			}
+		}
+		for _, f := range p.scanner.depFiles {
+			if same(f) {
+				what = "dependencies"
+			}
+		}
+		for _, f := range p.scanner.extraFiles() {
+			if same(f) && len(what) == 0 {
+				what = "build override or hook file"
+			}
+		}
+		if len(what) != 0 {
+			fmt.Printf("  project %s: rebuild and restart (%s)\n", p.target(), what)
+			found = true
+		}
+	}
+
+	for _, w := range watchers {
+		for _, f := range w.scanner.srcs {
+			if same(f) {
+				fmt.Printf("  watcher %s: run %q, then restart all projects\n", w.name, w.command)
+				found = true
+				break
+			}
+		}
+	}
+
+	for _, c := range contents {
+		if same(c.path) {
+			fmt.Printf("  content %s: restart all projects when a line matches %q\n", c.path, c.re.String())
+			found = true
+		}
+	}
+
+	for _, f := range assets {
+		if same(f) {
+			fmt.Printf("  livereload: reload browsers, no restart\n")
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		fmt.Printf("  nothing, the file is not watched\n")
+	}
+}
+
+/* ----- */
+
+type Flags struct {
+	OutFile string `short:"o" long:"outfile" description:"Executable file" default:"lr-bin"`
+	Dirs []string `short:"d" long:"dirs" description:"Directory to watch"`
+	RunArgsCmd OSCommand `long:"run-args-cmd" description:"Command whose output is split into more child arguments, run before every start, prefix with os: for per-OS variants"`
+	ArgsFile string `long:"args-file" description:"File with child arguments, one per line, placed before any after --"`
+	Env []string `short:"e" long:"env" description:"Environment variable KEY=VALUE for the child (repeatable)"`
+	EnvFile string `long:"env-file" description:"File of KEY=VALUE lines for the child's environment, read on every start"`
+	WorkDir string `long:"workdir" description:"Working directory for the child"`
+	SSH string `long:"ssh" description:"Sync the current directory to user@host:path and build and run there"`
+	MemoryLimit string `long:"memory-limit" description:"Run the child in a cgroup with this memory limit, e.g. 256M (Linux, cgroup v2, needs root or delegation)"`
+	Netns bool `long:"netns" description:"Run the child in its own network namespace (Linux, needs root)"`
+	RunAs string `long:"run-as" description:"Run the child as user[:group] (Unix, needs root)"`
+	ExecTemplate OSCommand `long:"exec-template" description:"Run the child as this shell pipeline, {out} is the binary and {args} its arguments, e.g. \"{out} {args} | tee run.log\", prefix with os: for per-OS variants"`
+	PipeTo OSCommand `long:"pipe-to" description:"Feed the child's stdout to this command, started once and kept across reloads, prefix with os: for per-OS variants"`
+	PidFile string `long:"pidfile" description:"Keep the running child's pid in this file"`
+	StdinFile string `long:"stdin-file" description:"File to use as the child's stdin"`
+	ProcName string `long:"proc-name" description:"Process name (argv[0]) for the child"`
+	CacheDir string `long:"cache-dir" description:"Build with GOCACHE set to this directory, kept between sessions and apart from the usual cache"`
+	CleanEnv bool `long:"clean-env" description:"Build with only PATH, HOME, GOPATH, GOCACHE and --keep-env set"`
+	KeepEnv []string `long:"keep-env" description:"With --clean-env, pass this variable through, or set it as KEY=VALUE (repeatable)"`
+	BuildFlags []string `short:"b" long:"build-flag" description:"Extra go build flag, e.g. --build-flag=-race (repeatable)"`
+	BuildOverrides []string `long:"build-override" description:"Build with these flags and KEY=VALUE env when a matching file changed, as glob[,glob]:settings, e.g. \"*.c,*.h:CGO_ENABLED=1\", the files are watched too (repeatable)"`
+	BuildP int `long:"build-p" description:"Run at most this many compile jobs in parallel (go build -p), the toolchain decides by default"`
+	FastCheck bool `long:"fast-check" description:"Check the sources for syntax errors with gofmt before building"`
+	AlsoBuild []string `long:"also-build" description:"Also compile for this GOOS/GOARCH pair after each build, without running it (repeatable)"`
+	Presets []string `long:"preset" description:"Build flag bundle (repeatable)" choice:"dev" choice:"release" choice:"reproducible"`
+	Reproducible bool `long:"reproducible" description:"Same as --preset reproducible: -trimpath, -buildvcs=false and an empty build id"`
+	SkipInitialIfFresh bool `long:"skip-initial-if-fresh" description:"Run the existing binary at startup if no source is newer than it"`
+	WatchBinary bool `long:"watch-binary" description:"Don't build, restart when the output file is replaced"`
+	WatchDeps bool `long:"watch-deps" description:"Rebuild when go.mod or go.sum change, e.g. after go get -u"`
+	Git bool `long:"git" description:"Rebuild once after git checkout, stash etc. settle"`
+	GitSettle time.Duration `long:"git-settle" description:"Quiet time after a git operation before rebuilding" default:"1s"`
+	BuildJSON bool `long:"build-json" description:"Use go build -json and show errors grouped by file"`
+	NoSignalHandling bool `long:"no-signal-handling" description:"Leave signals to the child, golr exits when it does"`
+	ForwardSignals []string `long:"forward-signals" description:"Signals to relay to the child instead of exiting, e.g. TERM,HUP"`
+	Daemon bool `long:"daemon" description:"Run in the background, logging to --daemon-log"`
+	DaemonLog string `long:"daemon-log" description:"Log file for --daemon" default:"golr.log"`
+	DaemonPid string `long:"daemon-pid" description:"Pid file for --daemon and --stop" default:"golr.pid"`
+	Stop bool `long:"stop" description:"Stop the daemon named by --daemon-pid"`
+	InstallTo string `long:"install-to" description:"Copy the binary into this directory after each successful build, e.g. ~/go/bin"`
+	WriteBuildinfo string `long:"write-buildinfo" description:"Write a JSON file with the git commit, build time, Go version and build flags after each build"`
+	TouchOutput bool `long:"touch-output" description:"Update the output file's mtime after every successful build"`
+	FailOnOutput string `long:"fail-on-output" description:"Treat a build as failed when its output matches this regex"`
+	ExplainBuild bool `long:"explain-build" description:"Report how many packages were recompiled or cached"`
+	Test bool `long:"test" description:"Run go test on the source packages after each build, start only if they pass"`
+	TestRace bool `long:"test-race" description:"Like --test, with -race for the tests only"`
+	TestBinary bool `long:"test-binary" description:"Build with go test -c and run the test binary"`
+	TestRun string `long:"test-run" description:"Pattern for -test.run in --test-binary mode"`
+	TestFlags []string `long:"test-flag" description:"Extra test binary flag, e.g. --test-flag=-test.count=1 (repeatable)"`
+	Cover bool `long:"cover" description:"Build the test binary with -cover and report coverage merged across runs, implies --test-binary"`
+	CoverDir string `long:"cover-dir" description:"Directory for raw coverage data in --cover mode" default:".golr-cover"`
+	CoverProfile string `long:"cover-profile" description:"Merged coverage profile written after each run in --cover mode" default:"cover.out"`
+	OnExit OSCommand `long:"on-exit" description:"Command to run when golr exits, also after a signal, fatal error or a panic in the event loop, prefix with os: for per-OS variants"`
+	PreBuild []string `long:"pre-build" description:"Command to run before each build, prefix with fail_on_stderr: to fail on any stderr output, when=glob: to run only if a matching file changed, os: (e.g. linux:) to run only on that OS (repeatable)"`
+	PostBuild []string `long:"post-build" description:"Command to run after each successful build, same syntax as --pre-build (repeatable)"`
+	CheckAll bool `long:"check-all" description:"Also compile every package in the module with go build ./... and report failures"`
+	CheckAllBlock bool `long:"check-all-block" description:"Don't start the child when --check-all fails"`
+	Lint bool `long:"lint" description:"Run a linter after each build, only start the child if it passes"`
+	LintCmd OSCommand `long:"lint-cmd" description:"Linter command, the source package directories are appended" default:"golangci-lint run"`
+	NoValidate bool `long:"no-validate" description:"Skip the dry-run check of build flags at startup"`
+	KeepLogs int `long:"keep-logs" description:"Save each build's output in --logs-dir, keeping this many"`
+	LogsDir string `long:"logs-dir" description:"Directory for --keep-logs" default:".golr-logs"`
+	Artifacts []string `long:"artifacts" description:"After a good build, copy files matching this glob into a new set in --artifacts-dir (repeatable)"`
+	ArtifactsDir string `long:"artifacts-dir" description:"Directory for --artifacts" default:".golr-artifacts"`
+	KeepArtifacts int `long:"keep-artifacts" description:"How many --artifacts sets to keep" default:"10"`
+	Webhook string `long:"webhook" description:"POST a JSON build result to this URL after each build"`
+	WebhookSecret string `long:"webhook-secret" description:"Sent in the X-Golr-Secret header of webhook requests"`
+	Otel string `long:"otel" description:"Send a span for each build cycle and child run to this OTLP/HTTP collector, by default OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318" optional:"yes" optional-value:"env"`
+	TimingCSV string `long:"timing-csv" description:"Append a row per build (time, duration, result, changed file) to this CSV file"`
+	SummaryOnExit bool `long:"summary-on-exit" description:"Print session statistics when exiting"`
+	PauseWhenAttached bool `long:"pause-when-attached" description:"Defer restarts while a debugger is attached to the child (Linux)"`
+	BusyCheck OSCommand `long:"busy-check" description:"Command or URL that succeeds when the child is idle and can be restarted, prefix with os: (e.g. linux:) for per-OS variants"`
+	BusyTimeout time.Duration `long:"busy-timeout" description:"Maximum time to defer a restart while the child is busy" default:"30s"`
+	LogFile string `long:"logfile" description:"Also write the child's output to this file"`
+	StripChildColor bool `long:"strip-child-color" description:"Remove ANSI color codes from the child's output in --logfile"`
+	Prefix bool `long:"prefix" description:"Start each line of child output with its project's name, aligned and colored"`
+	Grep string `long:"grep" description:"Only show child output lines matching this regex on the terminal"`
+	MaxLogRate int `long:"max-log-rate" description:"Show at most this many child output lines per second on the terminal, the rest are counted"`
+	MergeOutput bool `long:"merge-output" description:"Send the child's stdout and stderr through one pipe, preserving their order"`
+	LineBuffered bool `long:"line-buffered" description:"Pass the child's output through golr line by line, and set PYTHONUNBUFFERED for programs that buffer when not on a terminal"`
+	HealthURL string `long:"health-url" description:"URL that answers below 400 once the child is ready"`
+	HealthAddr string `long:"health-addr" description:"TCP address that accepts connections once the child is ready"`
+	HealthInterval time.Duration `long:"health-interval" description:"Delay before the first health check and between checks" default:"250ms"`
+	HealthTimeout time.Duration `long:"health-timeout" description:"Timeout for a single health check" default:"1s"`
+	HealthRetries int `long:"health-retries" description:"Health checks to retry before giving up" default:"40"`
+	HealthBackoff float64 `long:"health-backoff" description:"Factor to grow the interval by after each failed check" default:"1"`
+	ChildReadyTimeout time.Duration `long:"child-ready-timeout" description:"Give up on the health check, --ready-log or --ready-file after this long, see --health-policy"`
+	MaxRestarts int `long:"max-restarts" description:"With --health-policy=restart or --restart-on-codes, stop restarting a failing child after this many tries"`
+	RestartOnCodes string `long:"restart-on-codes" description:"Restart the child when it exits with one of these codes, e.g. 137,139, or with any failure except !1,!2"`
+	ReadyLog string `long:"ready-log" description:"Consider the child ready once it prints a line matching this regex"`
+	ReadyFile string `long:"ready-file" description:"Consider the child ready once it creates this file, removed before each start"`
+	HealthPolicy string `long:"health-policy" description:"What to do when the child never becomes healthy" choice:"report" choice:"restart" default:"report"`
+	RestartCron string `long:"restart-cron" description:"Restart the child on a cron schedule, e.g. \"*/30 * * * *\" or @hourly"`
+	WaitForFile string `long:"wait-for-file" description:"Don't start the first build until this file exists"`
+	WaitForFileTimeout time.Duration `long:"wait-for-file-timeout" description:"Give up if --wait-for-file doesn't appear within this time"`
+	WaitCmd OSCommand `long:"wait-cmd" description:"Don't start the first build until this command exits 0, it's run again until it does (output shown with --trace), prefix with os: for per-OS variants"`
+	WaitCmdInterval time.Duration `long:"wait-cmd-interval" description:"Delay between --wait-cmd attempts" default:"1s"`
+	WaitCmdTimeout time.Duration `long:"wait-cmd-timeout" description:"Give up if --wait-cmd doesn't succeed within this time"`
+	Deadline string `long:"deadline" description:"Stop everything and exit with code 124 at this time, e.g. 10m from now, 17:30 or an RFC 3339 time"`
+	Cooldown time.Duration `long:"cooldown" description:"Minimum time between killing the child and starting the next one"`
+	WarmupURL string `long:"warmup-url" description:"URL to request once after the child starts"`
+	Open string `long:"open" description:"Open this URL in a browser once the child is first ready, {port} is the child's PORT variable" optional:"yes" optional-value:"http://localhost:{port}"`
+	WarmupMethod string `long:"warmup-method" description:"HTTP method for --warmup-url" default:"GET"`
+	WarmupBody string `long:"warmup-body" description:"Request body for --warmup-url"`
+	ReexecOnSelfChange bool `long:"reexec-on-self-change" description:"Restart golr itself when its executable changes (Unix)"`
+	Watchers []string `long:"watcher" description:"Extra pipeline as [os:]name:glob[,glob]:command, the child restarts when its command succeeds (repeatable)"`
+	Migrate []string `long:"migrate" description:"Run a command such as \"migrate up\" when .sql files in a directory change, as [os:]dir:command, the child restarts when it succeeds (repeatable)"`
+	Debounce time.Duration `long:"debounce" description:"Wait for source changes to stop for this long before rebuilding"`
+	SinceCommit string `long:"since-commit" description:"Only watch Go files that differ from this git ref, e.g. main, and what they embed, refreshed on SIGHUP"`
+	ShowChanges bool `long:"show-changes" description:"Before each build list every file that changed since the last one and how"`
+	RebuildGrace time.Duration `long:"rebuild-grace" description:"Ignore changes to files made within this long of a build starting, e.g. by code generators"`
+	RebuildGraceGlobs []string `long:"rebuild-grace-glob" description:"Only ignore these build outputs during --rebuild-grace, e.g. *_gen.go (repeatable)"`
+	DebounceEdge string `long:"debounce-edge" description:"Rebuild on the first change of a burst (leading), once it's over (trailing) or both" choice:"leading" choice:"trailing" choice:"both" default:"trailing"`
+	ReloadDebounce time.Duration `long:"reload-debounce" description:"Wait for watcher and content changes to stop for this long before restarting"`
+	Settle time.Duration `long:"settle" description:"Ignore changes for this long after the first build and start"`
+	Hybrid bool `long:"hybrid" description:"React to file notifications right away, scan every --hybrid-poll to catch missed ones"`
+	HybridPoll time.Duration `long:"hybrid-poll" description:"Scan interval with --hybrid" default:"3s"`
+	EmptyRecheck time.Duration `long:"empty-recheck" description:"When every watched file is gone, look for them again this often and rebuild once they're back, 0 to turn off" default:"2s"`
+	ScanGoroutine bool `long:"scan-goroutine" description:"Scan sources in a goroutine of their own, reporting scan errors such as no permission as they happen"`
+	IdlePause time.Duration `long:"idle-pause" description:"Check for changes less often after this long without terminal input (Linux)"`
+	Trace bool `long:"trace" description:"Explain why changes did or didn't lead to a rebuild or restart"`
+	ShowConfig bool `long:"show-config" description:"Print the resolved configuration as JSON and exit"`
+	Explain string `long:"explain" description:"Print what a change to this file would trigger and exit"`
+	WatchContent []string `long:"watch-content" description:"Restart the child when a file starts matching a regex, as path:regex (repeatable)"`
+	SingleInstance bool `long:"single-instance" description:"Refuse to run if another golr is running for the same module"`
+	ControlStdin bool `long:"control-stdin" description:"Read reload, restart, status, pause, resume and quit commands from golr's stdin, the child gets none"`
+	LiveReload string `long:"livereload" description:"Serve the LiveReload protocol on this address, e.g. :35729, and reload browsers after restarts"`
+	LiveReloadGlobs []string `long:"livereload-glob" description:"Assets that only reload the browser when they change, without a restart (repeatable)"`
+	Control string `long:"control" description:"Listen on this address for control requests, drive it with \"golr ctl\""`
+	StartOrder string `long:"start-order" description:"With --projects, build and start targets in file order (config), in reverse, or build all and then start them together (parallel)" choice:"config" choice:"reverse" choice:"parallel" default:"config"`
+	Projects string `long:"projects" description:"JSON file listing independent projects to build and run"`
+	Profile string `long:"profile" description:"Use the flags of this profile from --profiles-file, the command line overrides them"`
+	ProfilesFile string `long:"profiles-file" description:"JSON file of named profiles, each a list of golr flags" default:".golr-profiles.json"`
+	ListProfiles bool `long:"list-profiles" description:"List the profiles in --profiles-file and exit"`
+	CycleTimeout time.Duration `long:"cycle-timeout" description:"Maximum time for a change-to-ready cycle, e.g. 2m"`
+}
+
+func main() {
+	var args_this = os.Args[1:]
+	var args_child = make([]string, 0)
+
+	for i, val := range args_this {
+		if val == "--" {
+			args_child = args_this[i+1:]
+			args_this = args_this[:i]
+			break
+		}
+	}
+
+	if len(args_this) > 0 && args_this[0] == "ctl" {
+		if err := CtlMain(os.Args[2:]); err != nil {
+			fmt.Printf("%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var opts Flags
+	parser := flags.NewParser(&opts, flags.Default)
+	srcs, err := parser.ParseArgs(args_this)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	if opts.ListProfiles {
+		profiles, err := LoadProfiles(opts.ProfilesFile)
+		if err != nil {
+			FatalError(err.Error())
+		}
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%-16s %s\n", name, strings.Join(profiles[name], " "))
+		}
+		return
+	}
+
+	if len(opts.Profile) != 0 {
+		profiles, err := LoadProfiles(opts.ProfilesFile)
+		if err != nil {
+			FatalError(err.Error())
+		}
+		flagsOf, ok := profiles[opts.Profile]
+		if !ok {
+			FatalError(fmt.Sprintf("No profile %s in %s, see --list-profiles", opts.Profile, opts.ProfilesFile))
+		}
+		if slices.ContainsFunc(flagsOf, func(arg string) bool { return strings.HasPrefix(arg, "--profile") }) {
+			FatalError(fmt.Sprintf("Profile %s can't select another profile", opts.Profile))
+		}
+		fmt.Printf("Using profile %s\n", opts.Profile)
+
+		// Parsed again with the profile first, so the command line wins
+		opts = Flags{}
+		parser = flags.NewParser(&opts, flags.Default)
+		srcs, err = parser.ParseArgs(append(slices.Clone(flagsOf), args_this...))
+		if err != nil {
+			os.Exit(1)
+		}
+	}
+
+	tracing = opts.Trace
+
+	if opts.Stop {
+		if err := StopDaemon(opts.DaemonPid); err != nil {
+			FatalError(err.Error())
+		}
+		return
+	}
+
+	configs := make([]ProjectConfig, 0)
+	if len(opts.Projects) != 0 {
+		if len(srcs) != 0 {
+			FatalError("Source files can't be combined with --projects")
+		}
+		configs, err = LoadProjects(opts.Projects)
+		if err != nil {
+			FatalError(err.Error())
+		}
+	} else {
+		if len(srcs) == 0 && !opts.TestBinary && !opts.WatchBinary {
+			// No arguments, fine if the module has just one thing to run
+			pkgs, err := FindMainPackages([]string{"./..."})
+			if err == nil && len(pkgs) == 1 {
+				fmt.Printf("Running %s\n", pkgs[0].ImportPath)
+				srcs = pkgs[0].GoFiles
+			} else if err == nil && len(pkgs) > 1 {
+				names := make([]string, 0, len(pkgs))
+				for _, pkg := range pkgs {
+					names = append(names, pkg.ImportPath)
+				}
+				FatalError(fmt.Sprintf("No source files, and several main packages to choose from, pass one:\n  %s", strings.Join(names, "\n  ")))
+			}
+		}
+		if !opts.TestBinary && slices.ContainsFunc(srcs, func(src string) bool { return strings.Contains(src, "...") }) {
+			// Build and watch the files of the one package that can run
+			pkgs, err := FindMainPackages(srcs)
+			if err != nil {
+				FatalError(err.Error())
+			}
+			pkg, err := ChooseMainPackage(pkgs)
+			if err != nil {
+				FatalError(err.Error())
+			}
+			fmt.Printf("Running %s\n", pkg.ImportPath)
+			srcs = pkg.GoFiles
+		}
+
+		cfg := ProjectConfig{}
+		cfg.Srcs = srcs
+		cfg.OutFile = opts.OutFile
+		cfg.Args = args_child
+		cfg.Dirs = opts.Dirs
+		configs = append(configs, cfg)
+	}
+
+	if len(opts.CacheDir) != 0 {
+		opts.CacheDir, err = filepath.Abs(opts.CacheDir)
+		if err == nil {
+			err = os.MkdirAll(opts.CacheDir, 0755)
+		}
+		if err != nil {
+			FatalError(err.Error())
+		}
+		if tracing {
+			files, size := DirUsage(opts.CacheDir)
+			Trace("build cache %s: %d files, %d MB", opts.CacheDir, files, size >> 20)
+		}
+	}
+
+	if opts.Cover {
+		// Coverage is collected from test binaries
+		opts.TestBinary = true
+		opts.CoverDir, err = filepath.Abs(opts.CoverDir)
+		if err == nil {
+			err = os.MkdirAll(opts.CoverDir, 0755)
+		}
+		if err != nil {
+			FatalError(err.Error())
+		}
+	}
+
+	for _, cfg := range configs {
+		if len(cfg.Srcs) == 0 && !opts.WatchBinary {
+			FatalError(cfg.label() + "No source files")
+		}
+		if len(cfg.OutFile) == 0 {
+			FatalError(cfg.label() + "No output file")
+		}
+	}
+
+	if len(opts.ReadyLog) != 0 && (len(opts.HealthURL) != 0 || len(opts.HealthAddr) != 0) {
+		FatalError("--ready-log can't be combined with --health-url or --health-addr")
+	}
+	if opts.ScanGoroutine && (opts.Hybrid || opts.IdlePause > 0) {
+		FatalError("--scan-goroutine can't be combined with --hybrid or --idle-pause, they decide when the event loop scans")
+	}
+	if len(opts.ReadyFile) != 0 && (len(opts.HealthURL) != 0 || len(opts.HealthAddr) != 0 || len(opts.ReadyLog) != 0) {
+		FatalError("--ready-file can't be combined with --health-url, --health-addr or --ready-log")
+	}
+
+	if dir, ok := strings.CutPrefix(opts.InstallTo, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			FatalError(err.Error())
+		}
+		opts.InstallTo = filepath.Join(home, dir)
+	}
+	if len(opts.ExecTemplate.String()) != 0 && len(opts.SSH) != 0 {
+		FatalError("--exec-template can't be combined with --ssh")
+	}
+	if len(opts.InstallTo) != 0 && len(opts.SSH) != 0 {
+		FatalError("--install-to can't be combined with --ssh, the binary is on the remote host")
+	}
+
+	if opts.BuildP < 0 {
+		FatalError(fmt.Sprintf("bad --build-p %d, expected a positive number of jobs", opts.BuildP))
+	}
+	if len(opts.Artifacts) != 0 && opts.KeepArtifacts < 1 {
+		FatalError(fmt.Sprintf("bad --keep-artifacts %d, expected at least 1", opts.KeepArtifacts))
+	}
+
+	for _, target := range opts.AlsoBuild {
+		goos, goarch, ok := strings.Cut(target, "/")
+		if !ok || len(goos) == 0 || len(goarch) == 0 {
+			FatalError(fmt.Sprintf("bad --also-build %q, expected GOOS/GOARCH", target))
+		}
+	}
+
+	if opts.PauseWhenAttached && runtime.GOOS != "linux" {
+		fmt.Printf("Warning: --pause-when-attached only works on Linux\n")
+	}
+	if opts.Netns && runtime.GOOS != "linux" {
+		fmt.Printf("Warning: --netns only works on Linux\n")
+	}
+	if len(opts.MemoryLimit) != 0 && runtime.GOOS != "linux" {
+		fmt.Printf("Warning: --memory-limit only works on Linux\n")
+	}
+
+	daemonized := len(os.Getenv(daemonEnv)) != 0
+	if opts.Daemon && !daemonized && !opts.ShowConfig && len(opts.Explain) == 0 {
+		if err := StartDaemon(opts.DaemonLog, opts.DaemonPid); err != nil {
+			FatalError(err.Error())
+		}
+		return
+	}
+
+	var lockfile string
+	if opts.SingleInstance && !opts.ShowConfig && len(opts.Explain) == 0 {
+		root, err := FindModuleRoot()
+		if err == nil {
+			lockfile, err = LockModule(root)
+		}
+		if err != nil {
+			FatalError(err.Error())
+		}
+	}
+
+	// Channels and signals
+	pchan := make(chan PStateErr)
+	hchan := make(chan HealthResult)
+	rchan := make(chan ControlRequest)
+	cchan := make(chan os.Signal, 1)
+	forward := make(map[os.Signal]bool)
+	for _, list := range opts.ForwardSignals {
+		for _, name := range strings.Split(list, ",") {
+			sig, err := ParseSignal(strings.TrimSpace(name))
+			if err != nil {
+				FatalError(err.Error())
+			}
+			forward[sig] = true
+		}
+	}
+	notify := []os.Signal{os.Interrupt, syscall.SIGTERM}
+	for sig := range forward {
+		notify = append(notify, sig)
+	}
+	hup, hasHup := signalNames["HUP"]
+	if (len(opts.SinceCommit) != 0 || len(opts.Projects) != 0) && hasHup {
+		notify = append(notify, hup)
+	}
+	usr1, hasUsr1 := signalNames["USR1"]
+	if hasUsr1 && !forward[usr1] {
+		notify = append(notify, usr1)
+	}
+	if opts.NoSignalHandling {
+		// Signals are still caught so they don't kill golr, but nothing acts on them.
+		// The child gets the terminal's Ctrl-C itself and golr exits when it does.
+		// Ignoring them with signal.Ignore would be inherited by the child.
+		signal.Notify(make(chan os.Signal, 1), notify...)
+	} else {
+		signal.Notify(cchan, notify...)
+	}
+
+	// Child output piping, needed when it goes anywhere besides the terminal
+	var output *OutputPipe
+	if len(opts.LogFile) != 0 || opts.MergeOutput || len(opts.Grep) != 0 || len(opts.ReadyLog) != 0 || opts.Prefix || opts.MaxLogRate > 0 || opts.LineBuffered {
+		var log io.Writer
+		if len(opts.LogFile) != 0 {
+			f, err := os.OpenFile(opts.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				FatalError(err.Error())
+			}
+			defer f.Close()
+
+			log = f
+			if opts.StripChildColor {
+				log = NewAnsiStripper(f)
+			}
+		}
+		output = NewOutputPipe(log)
+		output.merge = opts.MergeOutput
+		output.maxRate = opts.MaxLogRate
+		if len(opts.Grep) != 0 {
+			output.grep, err = regexp.Compile(opts.Grep)
+			if err != nil {
+				FatalError(err.Error())
+			}
+		}
+	}
+
+	// Scanner, builder and runner for each project
+	var pipeTo *PipeTo
+	if command := opts.PipeTo.String(); len(command) != 0 {
+		pipeTo = NewPipeTo(command)
+	}
+	var otel *Otel
+	if len(opts.Otel) != 0 {
+		endpoint := opts.Otel
+		if endpoint == "env" {
+			// The variable the OpenTelemetry SDKs read, or their default
+			endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+			if len(endpoint) == 0 {
+				endpoint = "http://localhost:4318"
+			}
+		}
+		otel = NewOtel(endpoint)
+	}
+
+	projects := make([]*Project, 0, len(configs))
+	for _, cfg := range configs {
+		p, err := NewProject(cfg, &opts, pchan, hchan)
+		if err != nil {
+			FatalError(cfg.label() + err.Error())
+		}
+		p.runner.output = output
+		p.runner.pipeTo = pipeTo
+		p.otel = otel
+		projects = append(projects, p)
+	}
+	if opts.Prefix {
+		names := make([]string, 0, len(projects))
+		for _, p := range projects {
+			names = append(names, p.target())
+		}
+		for i, pr := range MakePrefixes(names, IsTerminal(os.Stdout)) {
+			projects[i].runner.prefix = pr
+		}
+	}
+
+	// Extra pipelines sharing the run step
+	watchers := make([]*Watcher, 0, len(opts.Watchers))
+	for _, spec := range opts.Watchers {
+		w, err := ParseWatcher(spec)
+		if err != nil {
+			FatalError(err.Error())
+		}
+		if w != nil {
+			watchers = append(watchers, w)
+		}
+	}
+	for _, spec := range opts.Migrate {
+		w, err := ParseMigrate(spec)
+		if err != nil {
+			FatalError(err.Error())
+		}
+		if w != nil {
+			watchers = append(watchers, w)
+		}
+	}
+
+	// Content triggers
+	contents := make([]*ContentWatch, 0, len(opts.WatchContent))
+	for _, spec := range opts.WatchContent {
+		c, err := ParseContentWatch(spec)
+		if err != nil {
+			FatalError(err.Error())
+		}
+		contents = append(contents, c)
+	}
+
+	if opts.ShowConfig {
+		ShowConfig(parser, projects, watchers)
+		return
+	}
+
+	if len(opts.Explain) != 0 {
+		var assets []string
+		if len(opts.LiveReload) != 0 {
+			assets = ExpandGlobs(opts.LiveReloadGlobs)
+		}
+		ExplainChange(opts.Explain, projects, watchers, contents, assets)
+		return
+	}
+
+	// Signals end the event loop normally, so between this and FatalError
+	// the hook runs on every way out except SIGKILL and panics in other goroutines
+	exitHook = opts.OnExit.String()
+	defer func() {
+		r := recover()
+		RunExitHook()
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	if len(opts.Deadline) != 0 {
+		deadline, err := ParseDeadline(opts.Deadline, time.Now())
+		if err != nil {
+			FatalError(err.Error())
+		}
+		if !deadline.After(time.Now()) {
+			FatalError(fmt.Sprintf("--deadline %s has already passed", opts.Deadline))
+		}
+		var cancel context.CancelFunc
+		rootContext, cancel = context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		fmt.Printf("Deadline: %s, in %s\n", deadline.Format(time.RFC3339), time.Until(deadline).Round(time.Second))
+	}
+
+	// Something else produces a file the build needs
+	if len(opts.WaitForFile) != 0 {
+		fmt.Printf("Waiting for %s\n", opts.WaitForFile)
+		waitStart := time.Now()
+		for {
+			if _, err := os.Stat(opts.WaitForFile); err == nil {
+				break
+			}
+			if opts.WaitForFileTimeout > 0 && time.Since(waitStart) > opts.WaitForFileTimeout {
+				FatalError(fmt.Sprintf("%s did not appear within %s", opts.WaitForFile, opts.WaitForFileTimeout))
+			}
+			if rootContext.Err() != nil {
+				DeadlineExit("waiting for " + opts.WaitForFile)
+			}
+			select {
+			case sig := <-cchan:
+				fmt.Printf("Signal: %s\n", sig)
+				return
+			case <-time.After(250 * time.Millisecond):
+			}
+		}
+	}
+
+	if waitCmd := opts.WaitCmd.String(); len(waitCmd) != 0 {
+		fmt.Printf("Waiting for %s to succeed\n", waitCmd)
+		waitCtx := rootContext
+		if opts.WaitCmdTimeout > 0 {
+			// A hanging attempt doesn't get past the timeout either
+			var cancel context.CancelFunc
+			waitCtx, cancel = context.WithTimeout(waitCtx, opts.WaitCmdTimeout)
+			defer cancel()
+		}
+		for attempt := 1; ; attempt++ {
+			cmd := ShellCommand(waitCtx, waitCmd)
+			if tracing {
+				cmd.Stdout = os.Stdout
+				cmd.Stderr = os.Stderr
+			}
+			err := cmd.Run()
+			if err == nil {
+				fmt.Printf("Wait command succeeded after %d attempt(s)\n", attempt)
+				break
+			}
+			Trace("--wait-cmd attempt %d: %s", attempt, err)
+			if rootContext.Err() != nil {
+				DeadlineExit("waiting for " + waitCmd)
+			}
+			if waitCtx.Err() != nil {
+				FatalError(fmt.Sprintf("%s did not succeed within %s", waitCmd, opts.WaitCmdTimeout))
+			}
+			select {
+			case sig := <-cchan:
+				fmt.Printf("Signal: %s\n", sig)
+				return
+			case <-waitCtx.Done():
+			case <-time.After(opts.WaitCmdInterval):
+			}
+		}
+	}
+
+	if len(opts.Control) != 0 {
+		if err := StartControlServer(opts.Control, rchan); err != nil {
+			FatalError(err.Error())
+		}
+	}
+
+	if opts.ControlStdin {
+		go ReadControlStdin(rchan)
+	}
+
+	// Assets served from disk only need the browser to reload
+	var livereload *LiveReload
+	var assets *Scanner
+	if len(opts.LiveReload) != 0 {
+		livereload, err = StartLiveReload(opts.LiveReload)
+		if err != nil {
+			FatalError(err.Error())
+		}
+		assets = NewScanner(ExpandGlobs(opts.LiveReloadGlobs), nil)
+		for _, p := range projects {
+			p.livereload = livereload
+		}
+	}
+
+	// Session counters
+	stats := Stats{}
+	startTime := time.Now()
+
+	// Periodic restarts
+	var schedule cron.Schedule
+	var nextRestart time.Time
+	if len(opts.RestartCron) != 0 {
+		schedule, err = cron.ParseStandard(opts.RestartCron)
+		if err != nil {
+			FatalError(err.Error())
+		}
+		nextRestart = schedule.Next(time.Now())
+	}
+
+	// Changes right after startup are ignored
+	var settleUntil time.Time
+
+	// Watching slows down while the user is away from the terminal
+	idle := false
+	paused := false
+	lastScan := time.Time{}
+	if opts.IdlePause > 0 {
+		if _, ok := TerminalIdle(); !ok {
+			fmt.Printf("Warning: --idle-pause needs a terminal on stdin (Linux)\n")
+		}
+	}
+
+	// Edits to --projects are applied in place
+	var projectsFile *Scanner
+	if len(opts.Projects) != 0 {
+		projectsFile = NewScanner([]string{opts.Projects}, nil)
+	}
+
+	// Notifications for quick reloads, scanning every few seconds catches what they miss
+	var nchan chan string
+	notified := false
+	if opts.Hybrid {
+		dirs := make([]string, 0)
+		for _, p := range projects {
+			dirs = append(dirs, p.scanner.notifyDirs()...)
+		}
+		for _, w := range watchers {
+			dirs = append(dirs, w.scanner.notifyDirs()...)
+		}
+		for _, c := range contents {
+			dirs = append(dirs, filepath.Dir(c.path))
+		}
+		for _, glob := range opts.LiveReloadGlobs {
+			dirs = append(dirs, filepath.Dir(glob))
+		}
+		if projectsFile != nil {
+			dirs = append(dirs, projectsFile.notifyDirs()...)
+		}
+		slices.Sort(dirs)
+		nchan, err = NotifyChanges(slices.Compact(dirs))
+		if err != nil {
+			FatalError(err.Error())
+		}
+	}
+
+	// Our own executable, to re-exec when it's rebuilt
+	var self *Scanner
+	var selfExe string
+	selfChanged := false
+	if opts.ReexecOnSelfChange {
+		if runtime.GOOS == "windows" {
+			fmt.Printf("Warning: --reexec-on-self-change is not supported on Windows\n")
+		}
+		selfExe, err = os.Executable()
+		if err == nil {
+			selfExe, err = filepath.EvalSymlinks(selfExe)
+		}
+		if err != nil {
+			FatalError(err.Error())
+		}
+		self = NewScanner([]string{selfExe}, nil)
+	}
+
+	// Scanning off the event loop, changes and errors come back on channels
+	var schan chan *Scanner
+	var echan chan error
+	if opts.ScanGoroutine {
+		schan = make(chan *Scanner)
+		echan = make(chan error)
+		for _, p := range projects {
+			p.scanner.async = true
+			go p.scanner.run(250 * time.Millisecond, schan, echan)
+		}
+	}
+
+	// Event loop
+	// Order of builds and starts for targets in the same loop pass
+	ordered := slices.Clone(projects)
+	if opts.StartOrder == "reverse" {
+		slices.Reverse(ordered)
+	} else if opts.StartOrder == "parallel" {
+		for _, p := range ordered {
+			p.holdStart = true
+		}
+	}
+
+	deadlineHit := false
+	for !AllExited(projects) {
+		if rootContext.Err() != nil && !deadlineHit {
+			// Builds and checks are canceled by the context, children are stopped here
+			deadlineHit = true
+			fmt.Printf("Deadline reached, stopping\n")
+			for _, p := range projects {
+				p.runner.kill()
+				p.state = exiting
+			}
+		}
+		for _, p := range ordered {
+			if p.state == building {
+				p.build(&opts, &stats)
+			}
+		}
+		for _, p := range ordered {
+			if p.state == cooling && p.cooled(&opts) {
+				p.start(&opts)
+			}
+		}
+		for _, p := range ordered {
+			if p.pendingConfig != nil && p.state != killing && p.state != cooling {
+				p.reconfigure(*p.pendingConfig, &opts)
+			}
+		}
+		if settleUntil.IsZero() {
+			// Side effects of the first build must not trigger another one
+			settleUntil = time.Now().Add(opts.Settle)
+		}
+		settling := time.Now().Before(settleUntil)
+
+		select {
+		default:
+			if opts.IdlePause > 0 {
+				inactive, ok := TerminalIdle()
+				if ok && inactive > opts.IdlePause && !idle {
+					fmt.Printf("Terminal idle, checking for changes every %s\n", idleScanInterval)
+					idle = true
+				} else if idle && (!ok || inactive <= opts.IdlePause) {
+					fmt.Printf("Terminal active, resuming\n")
+					idle = false
+				}
+				if idle && time.Since(lastScan) < idleScanInterval {
+					break
+				}
+			}
+			// In hybrid mode a scan without a notification is the safety net. Only
+			// scanning waits for it, debounce and other timers are checked every pass,
+			// and pausing stops only the scanning too.
+			scan := !opts.Hybrid || notified || time.Since(lastScan) >= opts.HybridPoll
+			scan = scan && !paused
+			polled := scan && opts.Hybrid && !notified
+			if scan {
+				notified = false
+				lastScan = time.Now()
+			}
+
+			// Each watcher and content trigger waits for its own quiet period,
+			// so a burst of asset saves doesn't hold up anything else
+			for _, w := range watchers {
+				if scan && w.detect() {
+					if settling {
+						Trace("watcher %s: change ignored while settling", w.name)
+						continue
+					}
+					Trace("watcher %s: running after --reload-debounce (%s) of quiet", w.name, opts.ReloadDebounce)
+					w.changeAt = time.Now()
+				}
+				if w.changeAt.IsZero() || time.Since(w.changeAt) < opts.ReloadDebounce {
+					continue
+				}
+				w.changeAt = time.Time{}
+				if w.run() == nil {
+					RestartAll(projects, "restart")
+				} else {
+					Trace("watcher %s: command failed, not restarting", w.name)
+				}
+			}
+
+			for _, c := range contents {
+				if scan && c.detect() {
+					if settling {
+						Trace("content %s: match ignored while settling", c.path)
+						continue
+					}
+					Trace("content %s: restarting after --reload-debounce (%s) of quiet", c.path, opts.ReloadDebounce)
+					c.changeAt = time.Now()
+				}
+				if !c.changeAt.IsZero() && time.Since(c.changeAt) >= opts.ReloadDebounce {
+					c.changeAt = time.Time{}
+					RestartAll(projects, "restart")
+				}
+			}
+
+			if scan && projectsFile != nil && projectsFile.detect() {
+				ReloadProjects(opts.Projects, projects, &opts)
+			}
+
+			if scan && assets != nil {
+				// Globs are expanded on every scan to pick up new files
+				assets.srcs = ExpandGlobs(opts.LiveReloadGlobs)
+				if !assets.detect() {
+					// Nothing changed
+				} else if settling {
+					Trace("livereload: asset change ignored while settling")
+				} else {
+					livereload.reload(assets.lastChanged)
+				}
+			}
+
+			if schedule != nil && !time.Now().Before(nextRestart) {
+				fmt.Printf("Scheduled restart\n")
+				RestartAll(projects, "cron")
+				nextRestart = schedule.Next(time.Now())
+			}
+
+			if self != nil && (selfChanged || scan && self.detect()) {
+				// Don't let a binary that keeps changing re-exec us in a loop
+				selfChanged = true
+				if time.Since(startTime) >= reexecGuard {
+					for _, p := range projects {
+						p.runner.kill()
+					}
+					fmt.Printf("Re-executing %s\n", selfExe)
+					if err := Reexec(selfExe); err != nil {
+						FatalError(err.Error())
+					}
+				}
+			}
+
+			for _, p := range projects {
+				active := p.state == running || p.state == killing || p.state == cooling
+				fire := false
+				if active && scan && p.detect() {
+					if polled {
+						Trace("%s: the poll found a change file notifications missed", p.target())
+					}
+					if settling {
+						fmt.Printf("Ignoring change while settling\n")
+						continue
+					}
+					if opts.DebounceEdge != "trailing" && time.Now().After(p.leadingUntil) {
+						// The first change after a quiet period rebuilds right away
+						Trace("%s: rebuilding on the leading edge", p.target())
+						fire = true
+					} else if opts.DebounceEdge != "leading" {
+						// Every change starts the quiet period over
+						if opts.Debounce > 0 {
+							Trace("%s: rebuilding after --debounce (%s) of quiet", p.target(), opts.Debounce)
+						}
+						p.changeAt = time.Now()
+					} else {
+						Trace("%s: change ignored, less than --debounce (%s) since the last one", p.target(), opts.Debounce)
+					}
+					if opts.DebounceEdge != "trailing" {
+						p.leadingUntil = time.Now().Add(opts.Debounce)
+					}
+				}
+				if fire || active && !p.changeAt.IsZero() && time.Since(p.changeAt) >= opts.Debounce {
+					p.changeAt = time.Time{}
+					stats.reloads += 1
+					p.restartOnly = false
+					p.runner.reason = "rebuild"
+					p.restarts = 0
+					p.backoff = 0
+					if reason := p.holdReason(&opts); len(reason) != 0 {
+						fmt.Printf("%s, deferring restart\n", reason)
+						p.state = deferring
+						p.deferStart = time.Now()
+					} else {
+						p.reload()
+					}
+				} else if p.state == deferring {
+					// Changes made while deferring go into the same rebuild
+					if scan {
+						p.detect()
+					}
+					if len(p.holdReason(&opts)) == 0 {
+						p.reload()
+					}
+				}
+			}
+
+		case pstate := <-pchan:
+			p := FindProject(projects, pstate.Runner)
+			if pstate.PState != nil {
+				p.runner.removePidfile(pstate.PState.Pid())
+			}
+			if pstate.PState != nil && p.runner.proc != nil && p.runner.proc.Pid != pstate.PState.Pid() {
+				// An older child, the current one is still running
+				Trace("%s: exit of replaced child %d ignored", p.target(), pstate.PState.Pid())
+				break
+			}
+			if pstate.Err != nil {
+				fmt.Printf("Process exited: %s\n", pstate.Err)
+			} else {
+				fmt.Printf("Process exited without error\n")
+			}
+			if p.otel != nil && pstate.PState != nil {
+				p.otel.run(p.target(), p.runStart, pstate.PState, p.state == killing)
+			}
+			if p.runner.cgroup != nil && p.runner.cgroup.oomKilled() {
+				fmt.Printf("Killed by the OOM killer, --memory-limit is %s\n", opts.MemoryLimit)
+			}
+			if p.state != killing && pstate.PState != nil && !pstate.PState.Success() {
+				stats.crashes += 1
+			}
+			if p.restarts > 0 && time.Since(p.runStart) >= stableRunTime {
+				p.restarts = 0
+				p.backoff = 0
+			}
+			if (p.state == killing) {
+				p.state = building
+			} else if p.stopped {
+				// Stopped by golr after --child-ready-timeout
+				p.stopped = false
+				p.state = running
+			} else if opts.TestBinary {
+				// Tests ran to completion, run them again on the next change
+				if opts.Cover {
+					CoverReport(opts.CoverDir, opts.CoverProfile)
+				}
+				fmt.Printf("Waiting for changes\n")
+				p.runner.proc = nil
+				p.state = running
+			} else if pstate.PState != nil && p.restartOnExit(ExitCode(pstate.PState)) {
+				fmt.Printf("Exit code %d is in --restart-on-codes\n", ExitCode(pstate.PState))
+				p.runner.proc = nil
+				if p.backOff(&opts) {
+					p.killTime = time.Now()
+					p.state = building
+				} else {
+					p.state = running
+				}
+			} else {
+				p.state = exiting
+			}
+
+		case res := <-hchan:
+			// Results for a child that's already gone are stale
+			if p := FindProjectByProc(projects, res.Proc); p != nil {
+				p.healthDone(&opts, res.Err)
+			}
+
+		case <-nchan:
+			notified = true
+
+		case s := <-schan:
+			// Picked up by the next pass, which also applies settling and debounce
+			if p := FindProjectByScanner(projects, s); p != nil {
+				p.scanned = true
+			}
+
+		case err := <-echan:
+			fmt.Printf("Scan error: %s\n", err)
+
+		case req := <-rchan:
+			if req.Action == "status" {
+				req.Reply <- ControlReply{StatusText(projects, paused), nil}
+				break
+			}
+			if req.Action == "pause" || req.Action == "resume" {
+				if paused != (req.Action == "pause") {
+					paused = req.Action == "pause"
+					if paused {
+						fmt.Printf("Paused, not checking for changes\n")
+					} else {
+						fmt.Printf("Resumed\n")
+					}
+				}
+				req.Reply <- ControlReply{}
+				break
+			}
+			if req.Action == "quit" {
+				fmt.Printf("Quit requested\n")
+				for _, p := range projects {
+					p.runner.kill()
+					p.state = exiting
+				}
+				req.Reply <- ControlReply{}
+				break
+			}
+			if len(req.Target) == 0 && len(projects) == 1 {
+				// Only one project, no need to name it
+				req.Target = projects[0].target()
+			}
+			p := FindProjectByTarget(projects, req.Target)
+			if p == nil {
+				req.Reply <- ControlReply{"", fmt.Errorf("no target %q", req.Target)}
+				break
+			}
+			if p.state != running && p.state != deferring {
+				req.Reply <- ControlReply{"", fmt.Errorf("target %q is busy", req.Target)}
+				break
+			}
+			if req.Action == "restart" {
+				fmt.Printf("Restart requested: %s\n", req.Target)
+				p.restartOnly = true
+				p.runner.reason = "restart"
+			} else {
+				fmt.Printf("Rebuild requested: %s\n", req.Target)
+				stats.reloads += 1
+				p.restartOnly = false
+				p.runner.reason = "rebuild"
+			}
+			p.reload()
+			req.Reply <- ControlReply{}
+
+		case sig := <- cchan:
+			if hasHup && sig == hup && (len(opts.Projects) != 0 || len(opts.SinceCommit) != 0) {
+				if len(opts.Projects) != 0 {
+					fmt.Printf("Signal: %s, reloading %s\n", sig, opts.Projects)
+					ReloadProjects(opts.Projects, projects, &opts)
+				}
+				if len(opts.SinceCommit) != 0 {
+					fmt.Printf("Signal: %s, refreshing the files changed since %s\n", sig, opts.SinceCommit)
+					for _, p := range projects {
+						p.scanner.mutex.Lock()
+						if err := p.scanner.watchSinceCommit(opts.SinceCommit); err != nil {
+							fmt.Printf("Can't refresh --since-commit: %s\n", err)
+						}
+						p.scanner.mutex.Unlock()
+					}
+				}
+				break
+			}
+			if hasUsr1 && sig == usr1 && !forward[sig] {
+				fmt.Printf("Status:\n%s", StatusText(projects, paused))
+				break
+			}
+			if forward[sig] {
+				// The child decides what to do, golr exits once it does
+				fmt.Printf("Forwarding signal: %s\n", sig)
+				for _, p := range projects {
+					if p.runner.proc != nil {
+						p.runner.proc.Signal(sig)
+					}
+				}
+				break
+			}
+			fmt.Printf("Signal: %s\n", sig)
+			for _, p := range projects {
+				p.runner.kill()
+				p.state = exiting
+			}
+		}
+
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	fmt.Printf("Done running\n")
+
+	if pipeTo != nil {
+		pipeTo.close()
+	}
+
+	if len(opts.PidFile) != 0 {
+		for _, p := range projects {
+			os.Remove(p.runner.pidfile)
+		}
+	}
+	for _, p := range projects {
+		if p.runner.cgroup != nil {
+			p.runner.cgroup.remove()
+		}
+	}
+
+	if daemonized {
+		os.Remove(opts.DaemonPid)
+	}
+	if len(lockfile) != 0 {
+		os.Remove(lockfile)
+	}
+	if len(opts.Control) != 0 {
+		os.Remove(controlAddrFile)
+	}
+
+	if opts.SummaryOnExit {
+		stats.print()
+	}
+	if tracing && len(opts.CacheDir) != 0 {
+		files, size := DirUsage(opts.CacheDir)
+		Trace("build cache %s: %d files, %d MB", opts.CacheDir, files, size >> 20)
+	}
+	if deadlineHit {
+		DeadlineExit(opts.Deadline)
+	}
 }
@@ -0,0 +1,220 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"golang.org/x/sys/unix"
+)
+
+func DebuggerAttached(pid int) bool {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "TracerPid:") {
+			tracer := strings.TrimSpace(strings.TrimPrefix(line, "TracerPid:"))
+			return tracer != "0"
+		}
+	}
+
+	return false
+}
+
+// Like w(1), uses the terminal's access time as the time of the last keypress
+func TerminalIdle() (time.Duration, bool) {
+	if !IsTerminal(os.Stdin) {
+		return 0, false
+	}
+
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return 0, false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	atime := time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec))
+	return time.Since(atime), true
+}
+
+// Starts the child in a network namespace of its own, which needs root
+func NetnsSysProcAttr(sys *syscall.SysProcAttr) *syscall.SysProcAttr {
+	if sys == nil {
+		sys = &syscall.SysProcAttr{}
+	}
+	sys.Cloneflags |= syscall.CLONE_NEWNET
+	return sys
+}
+
+// A new namespace only has a loopback interface, and it's down
+func LoopbackUp(pid int) error {
+	ns, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+
+	self, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		return err
+	}
+	defer self.Close()
+
+	// Namespaces are per thread, do it on one that can be thrown away
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+
+		if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			done <- err
+			return
+		}
+
+		err := ifaceUp("lo")
+
+		// If switching back fails the thread stays locked and exits with the goroutine
+		if unix.Setns(int(self.Fd()), unix.CLONE_NEWNET) == nil {
+			runtime.UnlockOSThread()
+		}
+		done <- err
+	}()
+	return <-done
+}
+
+func ifaceUp(name string) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	ifr.SetUint16(unix.IFF_UP | unix.IFF_LOOPBACK | unix.IFF_RUNNING)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
+
+// A cgroup v2 group next to golr's own, holding the child under a memory limit
+type MemoryCgroup struct {
+	path string
+	limit int64
+	created bool
+	ooms int
+}
+
+func NewMemoryCgroup(name string, limit int64) (*MemoryCgroup, error) {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		return nil, fmt.Errorf("--memory-limit needs cgroup v2 on /sys/fs/cgroup")
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	own := "/"
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			own = path
+		}
+	}
+
+	// A group with processes in it can't have children, so use a sibling
+	c := MemoryCgroup{}
+	c.path = filepath.Join("/sys/fs/cgroup", filepath.Dir(own), fmt.Sprintf("golr-%d-%s", os.Getpid(), name))
+	c.limit = limit
+	return &c, nil
+}
+
+// Made at the first spawn, so exiting before that leaves nothing behind
+func (c *MemoryCgroup) create() error {
+	if err := os.Mkdir(c.path, 0755); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("can't create a cgroup, this needs root or a delegated cgroup: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(c.path, "memory.max"), []byte(strconv.FormatInt(c.limit, 10)), 0644); err != nil {
+		os.Remove(c.path)
+		return fmt.Errorf("can't set the memory limit: %s", err)
+	}
+	// Swapping would hide the limit, not every kernel has the file though
+	os.WriteFile(filepath.Join(c.path, "memory.swap.max"), []byte("0"), 0644)
+
+	c.created = true
+	c.ooms = c.oomKills()
+	return nil
+}
+
+// The child is placed in the group as it's created, the returned function
+// closes the group's fd after the spawn
+func (c *MemoryCgroup) sysProcAttr(sys *syscall.SysProcAttr) (*syscall.SysProcAttr, func(), error) {
+	if !c.created {
+		if err := c.create(); err != nil {
+			return nil, nil, err
+		}
+	}
+	fd, err := unix.Open(c.path, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	attr := syscall.SysProcAttr{}
+	if sys != nil {
+		attr = *sys
+	}
+	attr.UseCgroupFD = true
+	attr.CgroupFD = fd
+	return &attr, func() { unix.Close(fd) }, nil
+}
+
+func (c *MemoryCgroup) oomKills() int {
+	if !c.created {
+		return 0
+	}
+	data, err := os.ReadFile(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			n, _ := strconv.Atoi(value)
+			return n
+		}
+	}
+	return 0
+}
+
+// True once per OOM kill since the last call
+func (c *MemoryCgroup) oomKilled() bool {
+	n := c.oomKills()
+	killed := n > c.ooms
+	c.ooms = n
+	return killed
+}
+
+// The child was only just sent SIGKILL, the group stays busy until it's gone
+func (c *MemoryCgroup) remove() {
+	if !c.created {
+		return
+	}
+	// Anything the child left behind too, not every kernel has the file though
+	os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0644)
+	var err error
+	for range 40 {
+		if err = os.Remove(c.path); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	fmt.Printf("Can't remove the cgroup %s: %s\n", c.path, err)
+}
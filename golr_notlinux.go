@@ -0,0 +1,44 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// Only Linux exposes the tracer pid without special privileges
+func DebuggerAttached(pid int) bool {
+	return false
+}
+
+func TerminalIdle() (time.Duration, bool) {
+	return 0, false
+}
+
+func NetnsSysProcAttr(sys *syscall.SysProcAttr) *syscall.SysProcAttr {
+	return sys
+}
+
+func LoopbackUp(pid int) error {
+	return nil
+}
+
+type MemoryCgroup struct {
+}
+
+func NewMemoryCgroup(name string, limit int64) (*MemoryCgroup, error) {
+	return nil, fmt.Errorf("--memory-limit only works on Linux")
+}
+
+func (c *MemoryCgroup) sysProcAttr(sys *syscall.SysProcAttr) (*syscall.SysProcAttr, func(), error) {
+	return sys, func() {}, nil
+}
+
+func (c *MemoryCgroup) oomKilled() bool {
+	return false
+}
+
+func (c *MemoryCgroup) remove() {
+}
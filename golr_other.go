@@ -0,0 +1,67 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+func DaemonSysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+func GroupSysProcAttr(sys *syscall.SysProcAttr) *syscall.SysProcAttr {
+	return sys
+}
+
+func KillGroup(proc *os.Process) error {
+	if runtime.GOOS != "windows" {
+		return proc.Kill()
+	}
+	// There are no process groups, taskkill walks the tree from the shell down
+	err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(proc.Pid)).Run()
+	if err != nil {
+		return proc.Kill()
+	}
+	return nil
+}
+
+func StopProcess(proc *os.Process) error {
+	return proc.Kill()
+}
+
+func ProcessAlive(pid int) bool {
+	// Finding a process fails on Windows once it has exited
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
+
+func ExitCode(ps *os.ProcessState) int {
+	return ps.ExitCode()
+}
+
+func IsExecutable(fi os.FileInfo) bool {
+	// There is no executable bit, the extension decides
+	return true
+}
+
+func Reexec(exe string) error {
+	return fmt.Errorf("re-executing golr is not supported on this platform")
+}
+
+func ChildSysProcAttr(opts *Flags) (*syscall.SysProcAttr, error) {
+	if len(opts.RunAs) != 0 {
+		fmt.Printf("Warning: --run-as is only supported on Unix\n")
+	}
+	return nil, nil
+}
+
+var signalNames = map[string]os.Signal{
+	"INT": os.Interrupt,
+	"TERM": syscall.SIGTERM,
+}
@@ -0,0 +1,80 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Runs golr in a re-executed test binary, stops it with SIGTERM and checks
+// that --on-exit ran exactly once
+func TestExitHookOnSignal(t *testing.T) {
+	if dir := os.Getenv("GOLR_TEST_EXIT_HOOK"); len(dir) != 0 {
+		sleep, err := exec.LookPath("sleep")
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Args = []string{"golr", "--watch-binary", "-o", sleep,
+			"--on-exit", "echo ran >> " + filepath.Join(dir, "hook.txt"), "--", "60"}
+		main()
+		return
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExitHookOnSignal$")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOLR_TEST_EXIT_HOOK="+dir)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signal once the child is running, so the event loop is what handles it
+	started := make(chan bool, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "Starting ") {
+				started <- true
+			}
+		}
+	}()
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("golr didn't start the child")
+	}
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("golr didn't exit after SIGTERM")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "hook.txt"))
+	if err != nil {
+		t.Fatalf("exit hook didn't run: %s", err)
+	}
+	if runs := strings.Count(string(data), "ran"); runs != 1 {
+		t.Fatalf("exit hook ran %d times, expected once", runs)
+	}
+}
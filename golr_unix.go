@@ -0,0 +1,116 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func DaemonSysProcAttr() *syscall.SysProcAttr {
+	// Detach from the terminal's session so closing it doesn't hang us up
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// Puts the child in a process group of its own, so a whole pipeline can be killed
+func GroupSysProcAttr(sys *syscall.SysProcAttr) *syscall.SysProcAttr {
+	if sys == nil {
+		sys = &syscall.SysProcAttr{}
+	}
+	sys.Setpgid = true
+	return sys
+}
+
+func KillGroup(proc *os.Process) error {
+	return syscall.Kill(-proc.Pid, syscall.SIGKILL)
+}
+
+func StopProcess(proc *os.Process) error {
+	return proc.Signal(syscall.SIGTERM)
+}
+
+func ProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Like a shell, a child killed by a signal exits with 128 plus the signal number
+func ExitCode(ps *os.ProcessState) int {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ps.ExitCode()
+}
+
+func IsExecutable(fi os.FileInfo) bool {
+	return fi.Mode()&0111 != 0
+}
+
+func Reexec(exe string) error {
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
+
+func ChildSysProcAttr(opts *Flags) (*syscall.SysProcAttr, error) {
+	if len(opts.RunAs) == 0 {
+		return nil, nil
+	}
+
+	cred, err := LookupCredential(opts.RunAs)
+	if err != nil {
+		return nil, err
+	}
+	return &syscall.SysProcAttr{Credential: cred}, nil
+}
+
+// Resolves user[:group], by name or numeric id
+func LookupCredential(spec string) (*syscall.Credential, error) {
+	name, group, _ := strings.Cut(spec, ":")
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, fmt.Errorf("unknown user %s", name)
+		}
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(group) != 0 {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return nil, fmt.Errorf("unknown group %s", group)
+			}
+		}
+		if gid, err = strconv.ParseUint(g.Gid, 10, 32); err != nil {
+			return nil, err
+		}
+	}
+
+	if os.Geteuid() != 0 && (int(uid) != os.Geteuid() || int(gid) != os.Getegid()) {
+		return nil, fmt.Errorf("running the child as %s needs root privileges", spec)
+	}
+
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
+
+var signalNames = map[string]os.Signal{
+	"HUP": syscall.SIGHUP,
+	"INT": syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+}